@@ -40,13 +40,90 @@ CREATE TABLE users
     id         uuid PRIMARY KEY      DEFAULT uuid_generate_v8(),
     name       VARCHAR(255) NOT NULL UNIQUE,
     password   TEXT         NOT NULL,
+    role       VARCHAR(16)  NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'admin')),
+    active     BOOLEAN      NOT NULL DEFAULT TRUE,
+    deleted_at TIMESTAMP,
     created_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
 CREATE TABLE weather_stations
 (
+    id          BIGSERIAL PRIMARY KEY,
     city        VARCHAR(255)   NOT NULL,
-    temperature NUMERIC(10, 4) NOT NULL
+    temperature NUMERIC(10, 4) NOT NULL,
+    measured_at TIMESTAMP      NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    uploaded_by uuid           REFERENCES users (id) ON DELETE SET NULL,
+    import_id   uuid,
+    keyed       BOOLEAN        NOT NULL DEFAULT FALSE -- 自然キー (city, measured_at) ごとに1行だけ TRUE にして突き合わせに使う
 );
 
+CREATE INDEX weather_stations_city_id ON weather_stations (city COLLATE "C", id);
+CREATE INDEX weather_stations_temperature_id ON weather_stations (temperature, id);
+-- 重複除外・上書きモードで使う自然キー。どのモードで取り込んだ行も各自然キーの最初の行が keyed になり、
+-- 追加モードで重複した2行目以降は keyed にしない。keyed の行を削除した場合は残っている最も古い行を keyed にする
+CREATE UNIQUE INDEX weather_stations_natural_key ON weather_stations (city, measured_at) WHERE keyed;
+-- keyed でない行も含めて自然キーで探す。keyed の行を削除したときの付け替えに使う
+CREATE INDEX weather_stations_city_measured_at ON weather_stations (city, measured_at);
+CREATE INDEX weather_stations_import_id ON weather_stations (import_id);
+CREATE INDEX users_created_at_id ON users (created_at, id);
+
+CREATE TABLE imports
+(
+    id             uuid PRIMARY KEY,
+    user_id        uuid         REFERENCES users (id) ON DELETE SET NULL,
+    batch_id       uuid,
+    filename       VARCHAR(255) NOT NULL,
+    checksum       VARCHAR(64)  NOT NULL DEFAULT '',
+    mode           VARCHAR(16)  NOT NULL,
+    encoding       VARCHAR(16)  NOT NULL DEFAULT '',
+    unit           VARCHAR(1)   NOT NULL DEFAULT 'C',
+    status         VARCHAR(16)  NOT NULL,
+    rows_processed BIGINT       NOT NULL DEFAULT 0,
+    rows_rejected  BIGINT       NOT NULL DEFAULT 0,
+    inserted       BIGINT       NOT NULL DEFAULT 0,
+    updated        BIGINT       NOT NULL DEFAULT 0,
+    skipped        BIGINT       NOT NULL DEFAULT 0,
+    deleted        BIGINT       NOT NULL DEFAULT 0,
+    error          TEXT         NOT NULL DEFAULT '',
+    started_at     TIMESTAMP,
+    finished_at    TIMESTAMP,
+    created_at     TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at     TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX imports_batch_id ON imports (batch_id);
+CREATE INDEX imports_user_id_created_at ON imports (user_id, created_at, id);
+
+CREATE TABLE import_errors
+(
+    id        BIGSERIAL PRIMARY KEY,
+    import_id uuid    NOT NULL REFERENCES imports (id) ON DELETE CASCADE,
+    line      INTEGER NOT NULL,
+    raw       TEXT    NOT NULL,
+    reason    TEXT    NOT NULL
+);
+
+CREATE INDEX import_errors_import_id ON import_errors (import_id, line);
+
+ALTER TABLE weather_stations
+    ADD FOREIGN KEY (import_id) REFERENCES imports (id) ON DELETE SET NULL;
+
+CREATE TABLE import_profiles
+(
+    id                 BIGSERIAL PRIMARY KEY,
+    user_id            uuid         NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name               VARCHAR(255) NOT NULL,
+    delimiter          VARCHAR(4)   NOT NULL DEFAULT ';',
+    quote              VARCHAR(16)  NOT NULL DEFAULT 'lazy',
+    comment            VARCHAR(4)   NOT NULL DEFAULT '#',
+    header             BOOLEAN      NOT NULL DEFAULT FALSE,
+    city_column        VARCHAR(255) NOT NULL,
+    temperature_column VARCHAR(255) NOT NULL,
+    measured_at_column VARCHAR(255) NOT NULL DEFAULT '',
+    decimal_separator  VARCHAR(1)   NOT NULL DEFAULT '.',
+    skip_rows          INTEGER      NOT NULL DEFAULT 0,
+    created_at         TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at         TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, name)
+);
@@ -1,29 +1,36 @@
 package csv
 
 import (
-	"encoding/csv"
+	"errors"
 	"fmt"
-	"go-form/core/database"
+	"go-form/core/charset"
 	"go-form/core/session"
+	"go-form/importer"
 	"go-form/repo"
-	"io"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
-func Csv(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodPost:
-		post(w, r)
-	case http.MethodGet:
-		get(w, r)
-	default:
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+func Csv(stations repo.WeatherStationStore, imports repo.ImportStore, profiles repo.ImportProfileStore, runner *importer.Runner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			post(w, r, imports, profiles, runner)
+		case http.MethodGet:
+			get(w, r, stations)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
 	}
 }
 
-func post(w http.ResponseWriter, r *http.Request) {
+func post(w http.ResponseWriter, r *http.Request, imports repo.ImportStore, profiles repo.ImportProfileStore, runner *importer.Runner) {
 	// セッションマネージャの初期化
 	manager, err := session.NewManager()
 	if err != nil {
@@ -46,163 +53,219 @@ func post(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// ファイルを取得
-	file, _, err := r.FormFile("csvfile")
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get uploaded file: %v", err), http.StatusBadRequest)
+	// ファイルをメモリに溜めずに保存する。その他のフィールドは upload.form に入る
+	uploadId := repo.NewImportId()
+	upload, ok := receive(w, r, uploadId)
+	if !ok {
 		return
 	}
-	defer file.Close()
-
-	// CSVリーダーを作成
-	reader := csv.NewReader(file)
-	reader.Comma = ';'             // 区切り文字を , から ; に変更
-	reader.Comment = '#'           // 先頭が # の場合はコメント行として扱う
-	reader.FieldsPerRecord = 2     // 各行のフィールド数。多くても少なくてもエラーになる
-	reader.LazyQuotes = true       // true の場合、"" が値の途中に "180"cm のようになっていてもエラーにならない
-	reader.TrimLeadingSpace = true // true の場合は、先頭の空白文字を無視する
-	reader.ReuseRecord = true      // true の場合は、Read で戻ってくるスライスを次回再利用する。パフォーマンスが上がる
-
-	db := database.DB()
-	defer db.Close()
-	wsRepo := repo.NewWeatherStationRepository(db)
-
-	var weatherStations []repo.WeatherStation
-	// 1行ずつ読み込んで処理
-	for {
-		record, err := reader.Read()
-		if err != nil {
-			if err == io.EOF {
-				break // ファイルの終わりに到達
-			}
-			http.Error(w, fmt.Sprintf("Failed to read CSV: %v", err), http.StatusInternalServerError)
-			return
-		}
-
-		// 不正な行はスキップ
-		if len(record) < 2 {
-			http.Error(w, "Invalid CSV format: insufficient columns", http.StatusBadRequest)
-			continue
-		}
-		var city string
-		var temperature float32
-		city = record[0]
-		v, err := strconv.ParseFloat(record[1], 32)
-		if err != nil {
-			log.Fatalf("Failed to convert temperature: %v", err)
-		}
-		temperature = float32(v)
+	path, form := upload.path, upload.form
 
-		weatherStations = append(weatherStations, repo.WeatherStation{City: city, Temperature: temperature})
+	opts, err := parseOptions(form, s.UserId(), profiles)
+	if err != nil {
+		os.Remove(path)
+		writeOptionsError(w, err)
+		return
+	}
+	opts.Format = formatOf(upload.filename)
 
-		if len(weatherStations) >= 1000 {
-			// データベースに挿入
-			err = wsRepo.BulkInsert(weatherStations)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("Failed to insert record: %v", err), http.StatusInternalServerError)
-				return
-			}
-			weatherStations = nil
-		}
+	// zip の場合は中の CSV ごとにジョブを作る
+	members, err := importer.Members(path)
+	if err != nil {
+		os.Remove(path)
+		http.Error(w, fmt.Sprintf("Invalid upload: %v", err), http.StatusBadRequest)
+		return
 	}
 
-	if weatherStations != nil {
-		// データベースに挿入
-		err = wsRepo.BulkInsert(weatherStations)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to insert record: %v", err), http.StatusInternalServerError)
+	staged := &importer.Staged{Id: uploadId, UserId: s.UserId(), Filename: upload.filename, Checksum: upload.checksum, Members: members, Options: opts, CreatedAt: time.Now()}
+	// プレビューの場合は保存せずに検証結果を表示し、確定か破棄を待つ
+	if form.Get("preview") != "" {
+		if err := importer.Stage(staged); err != nil {
+			log.Printf("Upload Stage Error: %v", err)
+			os.Remove(path)
+			http.Error(w, "Failed to save uploaded file", http.StatusInternalServerError)
 			return
 		}
+		preview(w, r, staged)
+		return
 	}
+	start(w, r, imports, runner, staged)
+}
 
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintln(w, "File uploaded and data saved successfully!")
+// 取り込みの設定が不正。メッセージはそのまま 400 で返す
+type optionsError struct {
+	message string
 }
 
-func get(w http.ResponseWriter, r *http.Request) {
-	// セッションマネージャの初期化
-	manager, err := session.NewManager()
+func (e *optionsError) Error() string {
+	return e.message
+}
+
+// フォームの mode, encoding, maxErrors, profile, sheet, headerRow から取り込みの設定を作る
+// 値が不正な場合は *optionsError を返す
+func parseOptions(form url.Values, userId string, profiles repo.ImportProfileStore) (importer.Options, error) {
+	mode, err := repo.ParseImportMode(form.Get("mode"))
 	if err != nil {
-		log.Printf("Session Manager Initialization Error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+		return importer.Options{}, &optionsError{err.Error()}
 	}
 
-	// セッション開始
-	s, err := manager.SessionStart(w, r)
-	if err != nil {
-		log.Printf("Session Start Error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+	encoding, err := charset.Parse(form.Get("encoding"), charset.Auto)
+	if err != nil || encoding == charset.UTF8BOM {
+		return importer.Options{}, &optionsError{"Unsupported encoding"}
 	}
 
-	user := s.Values["user"]
-	if user == nil {
-		http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
-		return
+	maxErrors := importer.DefaultMaxErrors
+	if v := form.Get("maxErrors"); v != "" {
+		maxErrors, err = strconv.Atoi(v)
+		if err != nil {
+			return importer.Options{}, &optionsError{"maxErrors must be an integer"}
+		}
 	}
 
-	// DB接続とリソースの解放を確実に行う
-	db := database.DB()
-	defer func() {
-		if err := db.Close(); err != nil {
-			log.Printf("Database Close Error: %v", err)
+	// 取り込みプロファイル。未選択の場合は標準の形式
+	profile := repo.DefaultImportProfile()
+	if v := form.Get("profile"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return importer.Options{}, &optionsError{"profile must be an integer"}
+		}
+		p, err := profiles.FindById(userId, id)
+		if errors.Is(err, repo.ErrNotFound) {
+			return importer.Options{}, &optionsError{"Import profile not found"}
+		}
+		if err != nil {
+			return importer.Options{}, err
+		}
+		profile = *p
+	}
+	// 見出し行の位置を指定した場合はプロファイルより優先する。xlsx で表の上にタイトルがある場合などに使う
+	if v := form.Get("headerRow"); v != "" {
+		row, err := strconv.Atoi(v)
+		if err != nil || row < 0 {
+			return importer.Options{}, &optionsError{"headerRow must be a non-negative integer"}
+		}
+		if row > 0 {
+			profile.Header = true
+			profile.SkipRows = row - 1
 		}
-	}()
+	}
+
+	if mode.Keyed() && profile.MeasuredAtColumn == "" {
+		return importer.Options{}, &optionsError{importer.ErrMeasuredAtRequired.Error()}
+	}
 
-	// ユーザーリポジトリからデータ取得
-	userRepo := repo.NewUserRepository(db)
-	rows, err := userRepo.FindAll()
+	unit, err := importer.ParseUnit(form.Get("unit"))
 	if err != nil {
-		log.Printf("Data Fetch Error: %v", err)
-		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
-		return
+		return importer.Options{}, &optionsError{err.Error()}
+	}
+	checks, err := parseChecks(form)
+	if err != nil {
+		return importer.Options{}, err
 	}
-	defer rows.Close()
 
-	// レスポンス用ヘッダー設定
-	w.Header().Set("Content-Type", "text/csv")
-	w.Header().Set("Content-Disposition", "attachment; filename=sample.csv")
+	return importer.Options{
+		Mode:       mode,
+		Profile:    profile,
+		Encoding:   encoding,
+		Sheet:      strings.TrimSpace(form.Get("sheet")),
+		Unit:       unit,
+		Checks:     checks,
+		UploadedBy: userId,
+		UploadedAt: time.Now(),
+		MaxErrors:  maxErrors,
+	}, nil
+}
 
-	// CSVライターの初期化
-	writer := csv.NewWriter(w)
+// 妥当な気温の範囲（摂氏）と小数点以下の桁数。範囲を指定しない場合は既定の範囲で確認する
+func parseChecks(form url.Values) (importer.Checks, error) {
+	checks := importer.DefaultChecks()
+	for _, f := range []struct {
+		name  string
+		value **float64
+	}{{"minTemperature", &checks.Min}, {"maxTemperature", &checks.Max}} {
+		if v := form.Get(f.name); v != "" {
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+				return checks, &optionsError{f.name + " must be a number"}
+			}
+			*f.value = &n
+		}
+	}
+	if *checks.Min > *checks.Max {
+		return checks, &optionsError{"minTemperature must not be greater than maxTemperature"}
+	}
+	// 0 は小数を許さない指定。空の場合だけ桁数を確認しない
+	if v := form.Get("decimals"); v != "" {
+		decimals, err := strconv.Atoi(v)
+		if err != nil || decimals < 0 {
+			return checks, &optionsError{"decimals must be a non-negative integer"}
+		}
+		checks.Decimals = &decimals
+	}
+	return checks, nil
+}
 
-	// ヘッダー行を書き込み
-	headers := []string{"id", "name", "created_at"}
-	if err := writer.Write(headers); err != nil {
-		log.Printf("CSV Header Write Error: %v", err)
-		http.Error(w, "Failed to write CSV header", http.StatusInternalServerError)
+// parseOptions のエラーをレスポンスに書き込む
+func writeOptionsError(w http.ResponseWriter, err error) {
+	var optErr *optionsError
+	if errors.As(err, &optErr) {
+		http.Error(w, optErr.message, http.StatusBadRequest)
 		return
 	}
+	log.Printf("Import Profile Fetch Error: %v", err)
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
 
-	// データ行を書き込み
-	for rows.Next() {
-		var id, name, createdAt string
-		if err := rows.Scan(&id, &name, &createdAt); err != nil {
-			log.Printf("Row Scan Error: %v", err)
-			http.Error(w, "Failed to scan data", http.StatusInternalServerError)
-			return
-		}
+// 保存したアップロードの取り込みジョブを開始して状況の画面へリダイレクトする
+func start(w http.ResponseWriter, r *http.Request, imports repo.ImportStore, runner *importer.Runner, upload *importer.Staged) {
+	location, err := startImports(imports, runner, upload)
+	if err != nil {
+		log.Printf("Import Create Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, location, http.StatusSeeOther)
+}
 
-		record := []string{id, name, createdAt}
-		if err := writer.Write(record); err != nil {
-			log.Printf("CSV Record Write Error: %v", err)
-			http.Error(w, "Failed to write CSV record", http.StatusInternalServerError)
-			return
+// 保存したアップロードの取り込みジョブを開始して、状況の画面の URL を返す
+// zip の場合は中の CSV ごとにジョブを作る。失敗した場合はファイルを削除する
+func startImports(imports repo.ImportStore, runner *importer.Runner, upload *importer.Staged) (string, error) {
+	path, opts := upload.Path(), upload.Options
+	if len(upload.Members) == 1 && upload.Members[0] == "" {
+		imp := &repo.Import{Id: upload.Id, UserId: upload.UserId, Filename: upload.Filename, Checksum: upload.Checksum, Mode: opts.Mode, Encoding: string(opts.Encoding), Unit: string(opts.Unit)}
+		if err := imports.Create(imp); err != nil {
+			os.Remove(path)
+			return "", err
 		}
+		opts.ImportId = imp.Id
+		runner.Start(imp, path, opts)
+		return "/imports/" + imp.Id, nil
 	}
 
-	// データ取得中のエラー確認
-	if err := rows.Err(); err != nil {
-		log.Printf("Row Iteration Error: %v", err)
-		http.Error(w, "Error iterating over rows", http.StatusInternalServerError)
-		return
+	var imps []*repo.Import
+	for _, member := range upload.Members {
+		imp := &repo.Import{UserId: upload.UserId, BatchId: upload.Id, Filename: memberName(upload.Filename, member), Checksum: upload.Checksum, Mode: opts.Mode, Encoding: string(opts.Encoding), Unit: string(opts.Unit)}
+		if err := imports.Create(imp); err != nil {
+			os.Remove(path)
+			// 登録済みのジョブは開始できないので失敗にしておく
+			for _, imp := range imps {
+				imp.Status = repo.StatusFailed
+				imp.Error = "failed to register archive"
+				imports.Update(imp)
+			}
+			return "", err
+		}
+		imps = append(imps, imp)
 	}
+	runner.StartArchive(imps, path, upload.Members, opts)
+	return "/batches/" + upload.Id, nil
+}
 
-	// 最後にバッファをフラッシュ
-	writer.Flush()
-	if err := writer.Error(); err != nil {
-		log.Printf("CSV Writer Flush Error: %v", err)
-		http.Error(w, "Error finalizing CSV", http.StatusInternalServerError)
+// archive.zip/member.csv の形式。imports.filename に収まるように先頭を切り詰める
+func memberName(archive, member string) string {
+	name := []rune(archive + "/" + member)
+	if len(name) > 255 {
+		name = name[len(name)-255:]
 	}
+	return string(name)
 }
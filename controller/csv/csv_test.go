@@ -0,0 +1,46 @@
+package csv
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseChecks(t *testing.T) {
+	tests := []struct {
+		name         string
+		form         url.Values
+		wantMin      float64
+		wantMax      float64
+		wantDecimals int // -1 は確認しない
+		wantErr      bool
+	}{
+		{"defaults", url.Values{}, -100, 100, -1, false},
+		{"range", url.Values{"minTemperature": {"-10"}, "maxTemperature": {"40.5"}}, -10, 40.5, -1, false},
+		{"zero range", url.Values{"minTemperature": {"0"}, "maxTemperature": {"0"}}, 0, 0, -1, false},
+		{"zero decimals", url.Values{"decimals": {"0"}}, -100, 100, 0, false},
+		{"two decimals", url.Values{"decimals": {"2"}}, -100, 100, 2, false},
+		{"min above max", url.Values{"minTemperature": {"10"}, "maxTemperature": {"0"}}, 0, 0, 0, true},
+		{"not a number", url.Values{"maxTemperature": {"hot"}}, 0, 0, 0, true},
+		{"negative decimals", url.Values{"decimals": {"-1"}}, 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checks, err := parseChecks(tt.form)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if checks.Min == nil || *checks.Min != tt.wantMin || checks.Max == nil || *checks.Max != tt.wantMax {
+				t.Errorf("range = %v to %v, want %v to %v", checks.Min, checks.Max, tt.wantMin, tt.wantMax)
+			}
+			switch {
+			case tt.wantDecimals < 0 && checks.Decimals != nil:
+				t.Errorf("decimals = %d, want unchecked", *checks.Decimals)
+			case tt.wantDecimals >= 0 && (checks.Decimals == nil || *checks.Decimals != tt.wantDecimals):
+				t.Errorf("decimals = %v, want %d", checks.Decimals, tt.wantDecimals)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package csv
+
+import (
+	"errors"
+	"go-form/core/export"
+	"go-form/core/session"
+	"go-form/repo"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// 観測値を書き出す。形式はクエリの format か Accept ヘッダーで選び、既定は CSV
+// 既定の列の CSV は取り込みと同じ見出し行なしの city;temperature の形式なので、書き出したファイルはそのまま取り込める
+// columns, sort, order, city, min, max, import, from, to, limit で列と行を絞り込める
+func get(w http.ResponseWriter, r *http.Request, stations repo.WeatherStationStore) {
+	// セッションマネージャの初期化
+	manager, err := session.NewManager()
+	if err != nil {
+		log.Printf("Session Manager Initialization Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// セッション開始
+	s, err := manager.SessionStart(w, r)
+	if err != nil {
+		log.Printf("Session Start Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	user := s.Values["user"]
+	if user == nil {
+		http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+		return
+	}
+
+	format, err := export.Negotiate(r, export.Formats...)
+	if err != nil {
+		export.WriteError(w, err)
+		return
+	}
+	q := r.URL.Query()
+	columns, err := repo.WeatherStationExportColumns(export.ColumnNames(q))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := exportOptions(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rows, err := stations.Export(opts)
+	if err != nil {
+		if errors.Is(err, repo.ErrInvalidSort) || errors.Is(err, repo.ErrInvalidImport) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Data Fetch Error: %v", err)
+		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		return
+	}
+
+	export.Write(w, r, format, export.Table{
+		Name:      "weather_stations",
+		Columns:   columns.Names(),
+		Rows:      export.Rows(rows, columns.Values),
+		Delimiter: ';',
+		NoHeader:  importable(columns.Names()),
+	})
+}
+
+// 取り込みの標準の形式 (city;temperature;measured_at) の先頭からの列なら、見出し行なしでそのまま取り込める
+func importable(names []string) bool {
+	standard := []string{"city", "temperature", "measured_at"}
+	return len(names) >= 2 && len(names) <= len(standard) && slices.Equal(names, standard[:len(names)])
+}
+
+// クエリパラメータから書き出しの条件を組み立てる。日付は YYYY-MM-DD で、to はその日を含む
+func exportOptions(q url.Values) (repo.ExportOptions, error) {
+	opts := repo.ExportOptions{ListOptions: repo.ListOptions{
+		Sort:     q.Get("sort"),
+		Desc:     q.Get("order") == "desc",
+		City:     q.Get("city"),
+		ImportId: q.Get("import"),
+	}}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return opts, errors.New("limit must be a non-negative integer")
+		}
+		opts.Limit = limit
+	}
+	if v := q.Get("min"); v != "" {
+		minTemp, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return opts, errors.New("min must be a number")
+		}
+		opts.MinTemperature = &minTemp
+	}
+	if v := q.Get("max"); v != "" {
+		maxTemp, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return opts, errors.New("max must be a number")
+		}
+		opts.MaxTemperature = &maxTemp
+	}
+	if v := q.Get("from"); v != "" {
+		from, err := time.ParseInLocation(time.DateOnly, v, time.Local)
+		if err != nil {
+			return opts, errors.New("from must be YYYY-MM-DD")
+		}
+		opts.MeasuredFrom = from
+	}
+	if v := q.Get("to"); v != "" {
+		to, err := time.ParseInLocation(time.DateOnly, v, time.Local)
+		if err != nil {
+			return opts, errors.New("to must be YYYY-MM-DD")
+		}
+		// 終了日はその日を含める
+		opts.MeasuredTo = to.AddDate(0, 0, 1)
+	}
+	return opts, nil
+}
@@ -0,0 +1,93 @@
+package csv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-form/importer"
+	"go-form/repo"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"time"
+)
+
+// JSON で受け付ける Content-Type と、取り込み履歴に表示するファイル名
+var jsonContentTypes = map[string]string{
+	"application/json":     "measurements.json",
+	"application/x-ndjson": "measurements.ndjson",
+	"application/jsonl":    "measurements.jsonl",
+}
+
+// センサーなどから送られる JSON 配列か NDJSON の観測値を取り込む
+// 本文は CSV のアップロードと同じく保存してから取り込みジョブで読むので、202 と状況の URL を返す
+// クエリの mode と maxErrors は CSV のアップロードのフォームと同じ
+func Ingest(imports repo.ImportStore, profiles repo.ImportProfileStore, runner *importer.Runner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			ingest(w, r, imports, profiles, runner)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func ingest(w http.ResponseWriter, r *http.Request, imports repo.ImportStore, profiles repo.ImportProfileStore, runner *importer.Runner) {
+	userId, ok := uploader(w, r)
+	if !ok {
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	filename, ok := jsonContentTypes[mediaType]
+	if !ok {
+		http.Error(w, "Content-Type must be application/json or application/x-ndjson", http.StatusUnsupportedMediaType)
+		return
+	}
+	opts, err := parseOptions(r.URL.Query(), userId, profiles)
+	if err != nil {
+		writeOptionsError(w, err)
+		return
+	}
+	opts.Format = importer.FormatJson
+
+	if r.ContentLength > importer.MaxUploadBytes {
+		http.Error(w, tooLarge(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, importer.MaxUploadBytes)
+	uploadId := repo.NewImportId()
+	path, checksum, err := importer.SaveUpload(uploadId, r.Body)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, tooLarge(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		log.Printf("Upload Save Error: %v", err)
+		http.Error(w, "Failed to receive request body", http.StatusBadRequest)
+		return
+	}
+	// gzip で圧縮した本文も読めるが、zip は受け付けない
+	members, err := importer.Members(path)
+	if err != nil || len(members) != 1 || members[0] != "" {
+		os.Remove(path)
+		http.Error(w, fmt.Sprintf("Unsupported body: expected %s", mediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	staged := &importer.Staged{Id: uploadId, UserId: userId, Filename: filename, Checksum: checksum, Members: members, Options: opts, CreatedAt: time.Now()}
+	location, err := startImports(imports, runner, staged)
+	if err != nil {
+		log.Printf("Import Create Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"id": uploadId, "status": string(repo.StatusQueued), "location": location})
+}
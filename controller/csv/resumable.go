@@ -0,0 +1,289 @@
+package csv
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"go-form/core/session"
+	"go-form/importer"
+	"go-form/repo"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tus (https://tus.io/protocols/resumable-upload) の core, creation, termination に対応する
+const tusVersion = "1.0.0"
+
+// 分割アップロードを開始する
+// Upload-Length にファイル全体の大きさ、Upload-Metadata に filename と取り込みの設定（mode, encoding, maxErrors, profile）を渡す
+func Uploads(profiles repo.ImportProfileStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		switch r.Method {
+		case http.MethodPost:
+			createUpload(w, r, profiles)
+		case http.MethodOptions:
+			w.Header().Set("Tus-Version", tusVersion)
+			w.Header().Set("Tus-Extension", "creation,termination")
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(importer.MaxUploadBytes, 10))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// 分割アップロードの状態の確認（HEAD）、続きの送信（PATCH）、中止（DELETE）
+// 最後まで受け取った PATCH で取り込みジョブを開始し、状況の画面の URL を Import-Location で返す
+func Upload(imports repo.ImportStore, profiles repo.ImportProfileStore, runner *importer.Runner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		switch r.Method {
+		case http.MethodHead:
+			head(w, r)
+		case http.MethodPatch:
+			patch(w, r, imports, profiles, runner)
+		case http.MethodDelete:
+			terminate(w, r)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// ログインしているユーザーの ID を返す
+// 画面ではなくアップロード用のクライアントから呼ばれるので、ログインしていない場合はリダイレクトせずに 401 を返す
+func uploader(w http.ResponseWriter, r *http.Request) (string, bool) {
+	manager, err := session.NewManager()
+	if err != nil {
+		log.Printf("Session Manager Initialization Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return "", false
+	}
+	s, err := manager.SessionStart(w, r)
+	if err != nil {
+		log.Printf("Session Start Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return "", false
+	}
+	if s.UserId() == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	return s.UserId(), true
+}
+
+func createUpload(w http.ResponseWriter, r *http.Request, profiles repo.ImportProfileStore) {
+	userId, ok := uploader(w, r)
+	if !ok {
+		return
+	}
+	if v := r.Header.Get("Tus-Resumable"); v != "" && v != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Upload-Length must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+	if length > importer.MaxUploadBytes {
+		http.Error(w, tooLarge(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	metadata, err := parseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filename := metadata["filename"]
+	if filename == "" {
+		http.Error(w, "Upload-Metadata must include filename", http.StatusBadRequest)
+		return
+	}
+	if msg, ok := acceptable(filename, metadata["filetype"]); !ok {
+		http.Error(w, msg, http.StatusUnsupportedMediaType)
+		return
+	}
+	// 設定の誤りは最後まで送る前に知らせる
+	if _, err := parseOptions(metadataForm(metadata), userId, profiles); err != nil {
+		writeOptionsError(w, err)
+		return
+	}
+
+	upload := &importer.Resumable{Id: repo.NewImportId(), UserId: userId, Length: length, Metadata: metadata, CreatedAt: time.Now()}
+	if err := importer.CreateResumable(upload); err != nil {
+		log.Printf("Upload Create Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/uploads/"+upload.Id)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// パスの ID のアップロードを取得する。他のユーザーのアップロードの場合は 404 を返す
+func loadResumable(w http.ResponseWriter, r *http.Request) (*importer.Resumable, bool) {
+	userId, ok := uploader(w, r)
+	if !ok {
+		return nil, false
+	}
+	upload, err := importer.LoadResumable(r.PathValue("id"))
+	if errors.Is(err, importer.ErrResumableNotFound) || err == nil && upload.UserId != userId {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Upload Load Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return upload, true
+}
+
+func head(w http.ResponseWriter, r *http.Request) {
+	upload, ok := loadResumable(w, r)
+	if !ok {
+		return
+	}
+	offset, err := upload.Offset()
+	if err != nil {
+		log.Printf("Upload Stat Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func patch(w http.ResponseWriter, r *http.Request, imports repo.ImportStore, profiles repo.ImportProfileStore, runner *importer.Runner) {
+	upload, ok := loadResumable(w, r)
+	if !ok {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Upload-Offset must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+
+	if r.ContentLength > upload.Length-offset {
+		http.Error(w, "Body exceeds Upload-Length", http.StatusBadRequest)
+		return
+	}
+
+	offset, err = upload.Append(offset, r.Body)
+	switch {
+	case errors.Is(err, importer.ErrOffsetMismatch):
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		http.Error(w, "Upload-Offset does not match the received size", http.StatusConflict)
+		return
+	case errors.Is(err, importer.ErrUploadBusy):
+		http.Error(w, "Upload is locked by another request", http.StatusLocked)
+		return
+	case errors.Is(err, importer.ErrExceedsLength):
+		http.Error(w, "Body exceeds Upload-Length", http.StatusBadRequest)
+		return
+	case errors.Is(err, importer.ErrResumableNotFound):
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	case err != nil:
+		// 途中で切断された場合も受け取った分は残っているので、HEAD で確認して続きから送れる
+		log.Printf("Upload Write Error: %v", err)
+		http.Error(w, "Failed to write upload", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if offset < upload.Length {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// 全て受け取ったので通常のアップロードと同じように取り込む
+	path, checksum, err := upload.Complete()
+	if errors.Is(err, importer.ErrResumableNotFound) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Upload Complete Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	opts, err := parseOptions(metadataForm(upload.Metadata), upload.UserId, profiles)
+	if err != nil {
+		os.Remove(path)
+		writeOptionsError(w, err)
+		return
+	}
+	opts.Format = formatOf(upload.Metadata["filename"])
+	members, err := importer.Members(path)
+	if err != nil {
+		os.Remove(path)
+		http.Error(w, fmt.Sprintf("Invalid upload: %v", err), http.StatusBadRequest)
+		return
+	}
+	staged := &importer.Staged{Id: upload.Id, UserId: upload.UserId, Filename: upload.Metadata["filename"], Checksum: checksum, Members: members, Options: opts, CreatedAt: time.Now()}
+	location, err := startImports(imports, runner, staged)
+	if err != nil {
+		log.Printf("Import Create Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Import-Location", location)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func terminate(w http.ResponseWriter, r *http.Request) {
+	upload, ok := loadResumable(w, r)
+	if !ok {
+		return
+	}
+	if err := upload.Terminate(); err != nil {
+		log.Printf("Upload Terminate Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// "filename d2VhdGhlci5jc3Y=,mode dXBzZXJ0" の形式。値は base64 で、省略できる
+func parseMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("invalid Upload-Metadata")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata value for %s", key)
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, nil
+}
+
+func metadataForm(metadata map[string]string) url.Values {
+	form := url.Values{}
+	for k, v := range metadata {
+		form.Set(k, v)
+	}
+	return form
+}
@@ -0,0 +1,121 @@
+package csv
+
+import (
+	"errors"
+	"go-form/core/session"
+	"go-form/importer"
+	"go-form/repo"
+	views "go-form/template"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// プレビューしたアップロードを取り込む
+func Confirm(imports repo.ImportStore, runner *importer.Runner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			confirm(w, r, imports, runner)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// プレビューしたアップロードを取り込まずに削除する
+func Discard() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			discard(w, r)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// 保存せずに読み込んだ結果を表示する。zip の場合は中の CSV ごとに表示する
+func preview(w http.ResponseWriter, r *http.Request, staged *importer.Staged) {
+	var previews []*importer.Preview
+	for _, member := range staged.Members {
+		p, err := importer.PreviewUpload(r.Context(), staged.Path(), member, staged.Options)
+		if err != nil {
+			log.Printf("Upload Preview Error: %v", err)
+			http.Error(w, "Failed to read uploaded file", http.StatusInternalServerError)
+			return
+		}
+		previews = append(previews, p)
+	}
+
+	t, _ := template.ParseFS(views.Files, "preview.html")
+	err := t.Execute(w, map[string]interface{}{
+		"staged":   staged,
+		"previews": previews,
+	})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// パスの ID のアップロードを取得する。ログインしていない場合や他のユーザーのアップロードの場合は false を返す
+func loadStaged(w http.ResponseWriter, r *http.Request) (*importer.Staged, bool) {
+	manager, err := session.NewManager()
+	if err != nil {
+		log.Printf("Session Manager Initialization Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	s, err := manager.SessionStart(w, r)
+	if err != nil {
+		log.Printf("Session Start Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if s.UserId() == "" {
+		http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+		return nil, false
+	}
+
+	staged, err := importer.LoadStaged(r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, importer.ErrStagedNotFound) {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return nil, false
+		}
+		log.Printf("Staged Upload Load Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if staged.UserId != s.UserId() {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil, false
+	}
+	return staged, true
+}
+
+func confirm(w http.ResponseWriter, r *http.Request, imports repo.ImportStore, runner *importer.Runner) {
+	staged, ok := loadStaged(w, r)
+	if !ok {
+		return
+	}
+	// 二重に確定されないよう、先に設定を削除する
+	if err := staged.Commit(); err != nil {
+		log.Printf("Staged Upload Commit Error: %v", err)
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	start(w, r, imports, runner, staged)
+}
+
+func discard(w http.ResponseWriter, r *http.Request) {
+	staged, ok := loadStaged(w, r)
+	if !ok {
+		return
+	}
+	if err := staged.Discard(); err != nil {
+		log.Printf("Staged Upload Discard Error: %v", err)
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
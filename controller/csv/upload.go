@@ -0,0 +1,151 @@
+package csv
+
+import (
+	"errors"
+	"fmt"
+	"go-form/importer"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// multipart で受け取ったアップロード
+type upload struct {
+	form     url.Values // csvfile 以外のフィールド
+	filename string
+	path     string
+	checksum string
+}
+
+// csvfile 以外のフィールドの長さの上限
+const maxFieldBytes = 1024
+
+// 受け付ける拡張子。.gz はその前の拡張子で判定する
+var allowedExtensions = map[string]bool{".csv": true, ".tsv": true, ".txt": true, ".zip": true, ".xlsx": true}
+
+// 受け付ける Content-Type。ブラウザや OS によって CSV の Content-Type が異なるので広めに許可する
+var allowedContentTypes = map[string]bool{
+	"":                             true,
+	"text/csv":                     true,
+	"text/plain":                   true,
+	"text/tab-separated-values":    true,
+	"application/csv":              true,
+	"application/vnd.ms-excel":     true, // Windows で .csv に付く
+	"application/octet-stream":     true,
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/zip":              true,
+	"application/x-zip-compressed": true,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": true,
+}
+
+// 本文を先頭から順に読み、csvfile はメモリに溜めずに uploadId のファイルへ書き込む
+// 本文は importer.MaxUploadBytes までに制限する。失敗した場合はエラーのレスポンスを書き込んで false を返す
+func receive(w http.ResponseWriter, r *http.Request, uploadId string) (*upload, bool) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		http.Error(w, "Content-Type must be multipart/form-data", http.StatusUnsupportedMediaType)
+		return nil, false
+	}
+	if r.ContentLength > importer.MaxUploadBytes {
+		http.Error(w, tooLarge(), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, importer.MaxUploadBytes)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid multipart body: %v", err), http.StatusBadRequest)
+		return nil, false
+	}
+
+	u := &upload{form: url.Values{}}
+	fail := func(status int, msg string) (*upload, bool) {
+		if u.path != "" {
+			os.Remove(u.path)
+		}
+		http.Error(w, msg, status)
+		return nil, false
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				return fail(http.StatusRequestEntityTooLarge, tooLarge())
+			}
+			return fail(http.StatusBadRequest, fmt.Sprintf("Invalid multipart body: %v", err))
+		}
+
+		if part.FormName() != "csvfile" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFieldBytes+1))
+			if err != nil {
+				return fail(http.StatusBadRequest, fmt.Sprintf("Invalid multipart body: %v", err))
+			}
+			if len(value) > maxFieldBytes {
+				return fail(http.StatusBadRequest, fmt.Sprintf("Field %s is too long", part.FormName()))
+			}
+			u.form.Add(part.FormName(), string(value))
+			continue
+		}
+
+		if u.path != "" {
+			return fail(http.StatusBadRequest, "Only one csvfile can be uploaded at a time")
+		}
+		// 中身を読む前に拡張子と Content-Type を確認する
+		if msg, ok := acceptable(part.FileName(), part.Header.Get("Content-Type")); !ok {
+			return fail(http.StatusUnsupportedMediaType, msg)
+		}
+		u.filename = part.FileName()
+		u.path, u.checksum, err = importer.SaveUpload(uploadId, part)
+		if err != nil {
+			u.path = ""
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				return fail(http.StatusRequestEntityTooLarge, tooLarge())
+			}
+			log.Printf("Upload Save Error: %v", err)
+			return fail(http.StatusBadRequest, "Failed to receive uploaded file")
+		}
+	}
+
+	if u.path == "" {
+		return fail(http.StatusBadRequest, "csvfile is required")
+	}
+	return u, true
+}
+
+func acceptable(filename, contentType string) (string, bool) {
+	name := strings.TrimSuffix(strings.ToLower(filename), ".gz")
+	if ext := path.Ext(name); !allowedExtensions[ext] || ((ext == ".zip" || ext == ".xlsx") && name != strings.ToLower(filename)) {
+		return fmt.Sprintf("Unsupported file type %q: upload .csv, .tsv, .txt, .csv.gz, .zip or .xlsx", filename), false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if contentType == "" {
+		mediaType, err = "", nil
+	}
+	if err != nil || !allowedContentTypes[mediaType] {
+		return fmt.Sprintf("Unsupported content type %q", contentType), false
+	}
+	return "", true
+}
+
+// ファイル名の拡張子から読み込む形式を決める
+func formatOf(filename string) importer.Format {
+	if strings.EqualFold(path.Ext(filename), ".xlsx") {
+		return importer.FormatXlsx
+	}
+	return importer.FormatCsv
+}
+
+func tooLarge() string {
+	return fmt.Sprintf("Upload exceeds the maximum size of %d bytes", importer.MaxUploadBytes)
+}
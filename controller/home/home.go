@@ -1,21 +1,29 @@
 package home
 
 import (
+	"go-form/core/charset"
+	"go-form/core/export"
 	"go-form/core/session"
+	"go-form/importer"
+	"go-form/repo"
+	views "go-form/template"
 	"html/template"
+	"log"
 	"net/http"
 )
 
-func Home(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		get(w, r)
-	default:
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+func Home(profiles repo.ImportProfileStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			get(w, r, profiles)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
 	}
 }
 
-func get(w http.ResponseWriter, r *http.Request) {
+func get(w http.ResponseWriter, r *http.Request, profiles repo.ImportProfileStore) {
 	manager, err := session.NewManager()
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -27,9 +35,30 @@ func get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	user := s.Values["user"]
-	t, _ := template.ParseFiles("template/home.html")
+
+	// アップロードフォームで選べる取り込みプロファイル
+	var userProfiles []repo.ImportProfile
+	if s.UserId() != "" {
+		userProfiles, err = profiles.List(s.UserId())
+		if err != nil {
+			log.Printf("Import Profile List Error: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	t, _ := template.ParseFS(views.Files, "home.html")
 	err = t.Execute(w, map[string]interface{}{
-		"user": user,
+		"user":            user,
+		"modes":           repo.ImportModes,
+		"importEncodings": charset.ImportEncodings,
+		"exportEncodings": charset.ExportEncodings,
+		"formats":         export.Formats,
+		"stationColumns":  repo.WeatherStationColumnNames,
+		"units":           importer.Units,
+		"checks":          importer.DefaultChecks(),
+		"profiles":        userProfiles,
+		"defaultProfile":  repo.DefaultImportProfile(),
 	})
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
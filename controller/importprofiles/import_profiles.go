@@ -0,0 +1,248 @@
+package importprofiles
+
+import (
+	"errors"
+	"go-form/core/session"
+	"go-form/repo"
+	views "go-form/template"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// 一覧と新規作成
+func List(profiles repo.ImportProfileStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			create(w, r, profiles)
+		case http.MethodGet:
+			list(w, r, profiles)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// 1件の編集
+func Edit(profiles repo.ImportProfileStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			update(w, r, profiles)
+		case http.MethodGet:
+			edit(w, r, profiles)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func Delete(profiles repo.ImportProfileStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			remove(w, r, profiles)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// ログイン中のユーザーの ID を返す。ログインしていない場合はログイン画面へリダイレクトして false を返す
+func signedIn(w http.ResponseWriter, r *http.Request) (string, bool) {
+	manager, err := session.NewManager()
+	if err != nil {
+		log.Printf("Session Manager Initialization Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return "", false
+	}
+	s, err := manager.SessionStart(w, r)
+	if err != nil {
+		log.Printf("Session Start Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return "", false
+	}
+	if s.UserId() == "" {
+		http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+		return "", false
+	}
+	return s.UserId(), true
+}
+
+// パスの ID のプロファイルを取得する。他のユーザーのプロファイルは 404 にする
+func load(w http.ResponseWriter, r *http.Request, profiles repo.ImportProfileStore, userId string) (*repo.ImportProfile, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil, false
+	}
+	p, err := profiles.FindById(userId, id)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return nil, false
+		}
+		log.Printf("Import Profile Fetch Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return p, true
+}
+
+func list(w http.ResponseWriter, r *http.Request, profiles repo.ImportProfileStore) {
+	userId, ok := signedIn(w, r)
+	if !ok {
+		return
+	}
+	// 新規作成フォームには標準の形式を入れておく
+	p := repo.DefaultImportProfile()
+	p.Name = ""
+	render(w, profiles, userId, p, nil)
+}
+
+func edit(w http.ResponseWriter, r *http.Request, profiles repo.ImportProfileStore) {
+	userId, ok := signedIn(w, r)
+	if !ok {
+		return
+	}
+	p, ok := load(w, r, profiles, userId)
+	if !ok {
+		return
+	}
+	render(w, profiles, userId, *p, nil)
+}
+
+func create(w http.ResponseWriter, r *http.Request, profiles repo.ImportProfileStore) {
+	userId, ok := signedIn(w, r)
+	if !ok {
+		return
+	}
+	p, errMsg := fromForm(r)
+	p.UserId = userId
+	if len(errMsg) > 0 {
+		render(w, profiles, userId, p, errMsg)
+		return
+	}
+
+	err := profiles.Create(&p)
+	if errors.Is(err, repo.ErrDuplicate) {
+		render(w, profiles, userId, p, map[string][]string{"name": {"同じ名前のプロファイルが既にあります"}})
+		return
+	}
+	if err != nil {
+		log.Printf("Import Profile Create Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/import-profiles", http.StatusSeeOther)
+}
+
+func update(w http.ResponseWriter, r *http.Request, profiles repo.ImportProfileStore) {
+	userId, ok := signedIn(w, r)
+	if !ok {
+		return
+	}
+	stored, ok := load(w, r, profiles, userId)
+	if !ok {
+		return
+	}
+	p, errMsg := fromForm(r)
+	p.Id = stored.Id
+	p.UserId = userId
+	if len(errMsg) > 0 {
+		render(w, profiles, userId, p, errMsg)
+		return
+	}
+
+	err := profiles.Update(&p)
+	if errors.Is(err, repo.ErrDuplicate) {
+		render(w, profiles, userId, p, map[string][]string{"name": {"同じ名前のプロファイルが既にあります"}})
+		return
+	}
+	if err != nil {
+		log.Printf("Import Profile Update Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/import-profiles", http.StatusSeeOther)
+}
+
+func remove(w http.ResponseWriter, r *http.Request, profiles repo.ImportProfileStore) {
+	userId, ok := signedIn(w, r)
+	if !ok {
+		return
+	}
+	p, ok := load(w, r, profiles, userId)
+	if !ok {
+		return
+	}
+	if err := profiles.Delete(userId, p.Id); err != nil && !errors.Is(err, repo.ErrNotFound) {
+		log.Printf("Import Profile Delete Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/import-profiles", http.StatusSeeOther)
+}
+
+// 一覧と入力フォームを表示する。編集中のプロファイルは Id が 0 以外
+func render(w http.ResponseWriter, profiles repo.ImportProfileStore, userId string, p repo.ImportProfile, errMsg map[string][]string) {
+	list, err := profiles.List(userId)
+	if err != nil {
+		log.Printf("Import Profile List Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	t, _ := template.ParseFS(views.Files, "import_profiles.html")
+	err = t.Execute(w, map[string]interface{}{
+		"profiles":  list,
+		"profile":   p,
+		"delimiter": formatDelimiter(p.Delimiter),
+		"errMsg":    errMsg,
+	})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// フォームではタブを \t と入力する
+func formatDelimiter(delimiter string) string {
+	if delimiter == "\t" {
+		return `\t`
+	}
+	return delimiter
+}
+
+func parseDelimiter(value string) string {
+	if value == `\t` {
+		return "\t"
+	}
+	return value
+}
+
+func fromForm(r *http.Request) (repo.ImportProfile, map[string][]string) {
+	p := repo.ImportProfile{
+		Name:              strings.TrimSpace(r.FormValue("name")),
+		Delimiter:         parseDelimiter(r.FormValue("delimiter")),
+		Quote:             repo.QuoteMode(r.FormValue("quote")),
+		Comment:           r.FormValue("comment"),
+		Header:            r.FormValue("header") != "",
+		CityColumn:        strings.TrimSpace(r.FormValue("cityColumn")),
+		TemperatureColumn: strings.TrimSpace(r.FormValue("temperatureColumn")),
+		MeasuredAtColumn:  strings.TrimSpace(r.FormValue("measuredAtColumn")),
+		DecimalSeparator:  r.FormValue("decimalSeparator"),
+	}
+	errMsg := p.Validate()
+	if v := r.FormValue("skipRows"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			errMsg["skipRows"] = append(errMsg["skipRows"], "読み飛ばす行数は0以上の整数で入力してください")
+		}
+		p.SkipRows = n
+	}
+	return p, errMsg
+}
@@ -0,0 +1,129 @@
+package imports
+
+import (
+	"errors"
+	"go-form/core/session"
+	"go-form/repo"
+	views "go-form/template"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// ログイン中のユーザーの取り込み履歴
+func History(imports repo.ImportStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			history(w, r, imports)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// 完了した取り込みで追加した行を削除する
+func Rollback(imports repo.ImportStore, stations repo.WeatherStationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			rollback(w, r, imports, stations)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func history(w http.ResponseWriter, r *http.Request, imports repo.ImportStore) {
+	manager, err := session.NewManager()
+	if err != nil {
+		log.Printf("Session Manager Initialization Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s, err := manager.SessionStart(w, r)
+	if err != nil {
+		log.Printf("Session Start Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if s.UserId() == "" {
+		http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+		return
+	}
+
+	// 新しい順に表示する
+	q := r.URL.Query()
+	opts := repo.ListOptions{
+		Sort:   q.Get("sort"),
+		Desc:   q.Get("order") != "asc",
+		Cursor: q.Get("cursor"),
+		Status: repo.ImportStatus(q.Get("status")),
+	}
+	if v := q.Get("limit"); v != "" {
+		opts.Limit, err = strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "limit must be an integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, err := imports.List(s.UserId(), opts)
+	if err != nil {
+		if errors.Is(err, repo.ErrInvalidSort) || errors.Is(err, repo.ErrInvalidCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Import Fetch Error: %v", err)
+		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		return
+	}
+
+	var next string
+	if page.Next != "" {
+		q.Set("cursor", page.Next)
+		next = "/imports?" + q.Encode()
+	}
+
+	t, _ := template.ParseFS(views.Files, "imports.html")
+	err = t.Execute(w, map[string]interface{}{
+		"imports":  page.Items,
+		"next":     next,
+		"statuses": []repo.ImportStatus{repo.StatusQueued, repo.StatusRunning, repo.StatusSucceeded, repo.StatusFailed, repo.StatusCancelled, repo.StatusRolledBack},
+		"status":   opts.Status,
+		"sort":     opts.Sort,
+		"desc":     opts.Desc,
+	})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+func rollback(w http.ResponseWriter, r *http.Request, imports repo.ImportStore, stations repo.WeatherStationStore) {
+	imp, ok := load(w, r, imports)
+	if !ok {
+		return
+	}
+	if imp.Status != repo.StatusSucceeded {
+		http.Error(w, "Only succeeded imports can be rolled back", http.StatusConflict)
+		return
+	}
+
+	n, err := stations.DeleteByImport(imp.Id)
+	if err != nil {
+		log.Printf("Import Rollback Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Rolled back import %s: deleted %d rows", imp.Id, n)
+
+	imp.Status = repo.StatusRolledBack
+	if err := imports.Update(imp); err != nil {
+		log.Printf("Import Update Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/imports/"+imp.Id, http.StatusSeeOther)
+}
@@ -0,0 +1,146 @@
+package imports
+
+import (
+	"go-form/controller/signin"
+	"go-form/core/session"
+	"go-form/repo"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "sessions")
+	if err != nil {
+		panic(err)
+	}
+	session.Dir = dir
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// alice と bob を登録し、alice でログインしたセッションの Cookie を返す
+func signIn(t *testing.T) (alice, bob *repo.User, cookie *http.Cookie) {
+	t.Helper()
+	users := repo.NewMemoryUserStore()
+	alice, err := users.Create("alice", "password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err = users.Create("bob", "password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"userName": {"alice"}, "password": {"password1"}}
+	req := httptest.NewRequest(http.MethodPost, "/sign-in", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	signin.SignIn(users)(rec, req)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == session.SId {
+			return alice, bob, c
+		}
+	}
+	t.Fatalf("sign in: status %d, no session cookie", rec.Code)
+	return nil, nil, nil
+}
+
+func TestRollback(t *testing.T) {
+	measuredAt := time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		owner        string // alice か bob
+		status       repo.ImportStatus
+		wantStatus   int
+		wantImport   repo.ImportStatus // 取り込みジョブの状態
+		wantStations int64             // 残った行の数
+	}{
+		{"succeeded", "alice", repo.StatusSucceeded, http.StatusSeeOther, repo.StatusRolledBack, 1},
+		{"already rolled back", "alice", repo.StatusRolledBack, http.StatusConflict, repo.StatusRolledBack, 3},
+		{"failed", "alice", repo.StatusFailed, http.StatusConflict, repo.StatusFailed, 3},
+		{"running", "alice", repo.StatusRunning, http.StatusConflict, repo.StatusRunning, 3},
+		{"another user's import", "bob", repo.StatusSucceeded, http.StatusNotFound, repo.StatusSucceeded, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alice, bob, cookie := signIn(t)
+			owner := map[string]string{"alice": alice.Id, "bob": bob.Id}[tt.owner]
+			imports := repo.NewMemoryImportStore()
+			imp := &repo.Import{UserId: owner, Filename: "stations.csv", Mode: repo.ModeAppend}
+			if err := imports.Create(imp); err != nil {
+				t.Fatal(err)
+			}
+			imp.Status = tt.status
+			if err := imports.Update(imp); err != nil {
+				t.Fatal(err)
+			}
+			stations := repo.NewMemoryWeatherStationStore()
+			if _, err := stations.Import(repo.ModeAppend, []repo.WeatherStation{
+				{City: "Tokyo", Temperature: 10, MeasuredAt: measuredAt, ImportId: imp.Id},
+				{City: "Osaka", Temperature: 20, MeasuredAt: measuredAt, ImportId: imp.Id},
+				{City: "Tokyo", Temperature: 11, MeasuredAt: measuredAt, ImportId: repo.NewImportId()},
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/imports/"+imp.Id+"/rollback", nil)
+			req.SetPathValue("id", imp.Id)
+			req.AddCookie(cookie)
+			rec := httptest.NewRecorder()
+			Rollback(imports, stations)(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code == http.StatusSeeOther && rec.Header().Get("Location") != "/imports/"+imp.Id {
+				t.Errorf("Location = %q", rec.Header().Get("Location"))
+			}
+			got, err := imports.FindById(imp.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.wantImport {
+				t.Errorf("import status = %q, want %q", got.Status, tt.wantImport)
+			}
+			stats, err := stations.Stats(repo.StatsFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var n int64
+			for _, s := range stats {
+				n += s.Count
+			}
+			if n != tt.wantStations {
+				t.Errorf("%d rows left, want %d", n, tt.wantStations)
+			}
+		})
+	}
+}
+
+func TestRollbackUnknownImport(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+	}{
+		{"unknown id", repo.NewImportId()},
+		{"malformed id", "abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/imports/"+tt.id+"/rollback", nil)
+			req.SetPathValue("id", tt.id)
+			_, _, cookie := signIn(t)
+			req.AddCookie(cookie)
+			rec := httptest.NewRecorder()
+			Rollback(repo.NewMemoryImportStore(), repo.NewMemoryWeatherStationStore())(rec, req)
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("status = %d, want 404", rec.Code)
+			}
+		})
+	}
+}
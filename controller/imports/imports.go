@@ -0,0 +1,287 @@
+package imports
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"go-form/core/charset"
+	"go-form/core/session"
+	"go-form/importer"
+	"go-form/repo"
+	views "go-form/template"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func Import(imports repo.ImportStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			get(w, r, imports)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// zip でまとめてアップロードしたファイルごとの取り込み状況
+func Batch(imports repo.ImportStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			batch(w, r, imports)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func Cancel(imports repo.ImportStore, runner *importer.Runner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			cancel(w, r, imports, runner)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func Rejected(imports repo.ImportStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			rejected(w, r, imports)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// パスの ID の取り込みジョブを取得する。ログインしていない場合や他のユーザーのジョブの場合は false を返す
+func load(w http.ResponseWriter, r *http.Request, imports repo.ImportStore) (*repo.Import, bool) {
+	manager, err := session.NewManager()
+	if err != nil {
+		log.Printf("Session Manager Initialization Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	s, err := manager.SessionStart(w, r)
+	if err != nil {
+		log.Printf("Session Start Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if s.UserId() == "" {
+		http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+		return nil, false
+	}
+
+	imp, err := imports.FindById(r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return nil, false
+		}
+		log.Printf("Import Fetch Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if imp.UserId != s.UserId() {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil, false
+	}
+	return imp, true
+}
+
+type importJson struct {
+	Id            string     `json:"id"`
+	BatchId       string     `json:"batch_id,omitempty"`
+	Filename      string     `json:"filename"`
+	Checksum      string     `json:"checksum,omitempty"`
+	Mode          string     `json:"mode"`
+	Encoding      string     `json:"encoding"`
+	Unit          string     `json:"unit"`
+	Status        string     `json:"status"`
+	RowsProcessed int64      `json:"rows_processed"`
+	RowsRejected  int64      `json:"rows_rejected"`
+	Inserted      int64      `json:"inserted"`
+	Updated       int64      `json:"updated"`
+	Skipped       int64      `json:"skipped"`
+	Deleted       int64      `json:"deleted"`
+	Error         string     `json:"error,omitempty"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+func toJson(imp repo.Import) importJson {
+	return importJson{
+		Id:            imp.Id,
+		BatchId:       imp.BatchId,
+		Filename:      imp.Filename,
+		Checksum:      imp.Checksum,
+		Mode:          string(imp.Mode),
+		Encoding:      imp.Encoding,
+		Unit:          imp.Unit,
+		Status:        string(imp.Status),
+		RowsProcessed: imp.RowsProcessed,
+		RowsRejected:  imp.RowsRejected,
+		Inserted:      imp.Result.Inserted,
+		Updated:       imp.Result.Updated,
+		Skipped:       imp.Result.Skipped,
+		Deleted:       imp.Result.Deleted,
+		Error:         imp.Error,
+		StartedAt:     imp.StartedAt,
+		FinishedAt:    imp.FinishedAt,
+		CreatedAt:     imp.CreatedAt,
+		UpdatedAt:     imp.UpdatedAt,
+	}
+}
+
+func wantsJson(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func get(w http.ResponseWriter, r *http.Request, imports repo.ImportStore) {
+	imp, ok := load(w, r, imports)
+	if !ok {
+		return
+	}
+
+	if wantsJson(r) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(toJson(*imp)); err != nil {
+			log.Printf("JSON Encode Error: %v", err)
+		}
+		return
+	}
+
+	// 画面には先頭の100行だけ表示する
+	var rejected []repo.ImportError
+	for e, err := range imports.Errors(imp.Id) {
+		if err != nil {
+			log.Printf("Import Error Fetch Error: %v", err)
+			break
+		}
+		if len(rejected) >= 100 {
+			break
+		}
+		rejected = append(rejected, e)
+	}
+
+	t, _ := template.ParseFS(views.Files, "import.html")
+	err := t.Execute(w, map[string]interface{}{
+		"import":   imp,
+		"encoding": charset.Encoding(imp.Encoding),
+		"unit":     importer.Unit(imp.Unit),
+		"rejected": rejected,
+	})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+func batch(w http.ResponseWriter, r *http.Request, imports repo.ImportStore) {
+	manager, err := session.NewManager()
+	if err != nil {
+		log.Printf("Session Manager Initialization Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s, err := manager.SessionStart(w, r)
+	if err != nil {
+		log.Printf("Session Start Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if s.UserId() == "" {
+		http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+		return
+	}
+
+	batch, err := imports.FindByBatch(r.PathValue("id"))
+	if err != nil {
+		log.Printf("Import Fetch Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if len(batch) == 0 || batch[0].UserId != s.UserId() {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	if wantsJson(r) {
+		list := make([]importJson, 0, len(batch))
+		for _, imp := range batch {
+			list = append(list, toJson(imp))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(list); err != nil {
+			log.Printf("JSON Encode Error: %v", err)
+		}
+		return
+	}
+
+	done := true
+	for _, imp := range batch {
+		done = done && imp.Status.Done()
+	}
+	t, _ := template.ParseFS(views.Files, "batch.html")
+	err = t.Execute(w, map[string]interface{}{
+		"imports": batch,
+		"done":    done,
+	})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+func cancel(w http.ResponseWriter, r *http.Request, imports repo.ImportStore, runner *importer.Runner) {
+	imp, ok := load(w, r, imports)
+	if !ok {
+		return
+	}
+	if !imp.Status.Done() {
+		runner.Cancel(imp.Id)
+	}
+	http.Redirect(w, r, "/imports/"+imp.Id, http.StatusSeeOther)
+}
+
+// 取り込めなかった行を line,raw,reason の CSV でダウンロードする
+func rejected(w http.ResponseWriter, r *http.Request, imports repo.ImportStore) {
+	imp, ok := load(w, r, imports)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=rejected.csv")
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"line", "raw", "reason"}); err != nil {
+		log.Printf("CSV Header Write Error: %v", err)
+		return
+	}
+	for e, err := range imports.Errors(imp.Id) {
+		if err != nil {
+			log.Printf("Import Error Fetch Error: %v", err)
+			return
+		}
+		if err := writer.Write([]string{strconv.Itoa(e.Line), e.Raw, e.Reason}); err != nil {
+			log.Printf("CSV Record Write Error: %v", err)
+			return
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("CSV Writer Flush Error: %v", err)
+	}
+}
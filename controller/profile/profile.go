@@ -0,0 +1,138 @@
+package profile
+
+import (
+	"errors"
+	"go-form/core/session"
+	"go-form/repo"
+	views "go-form/template"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+func Profile(users repo.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			post(w, r, users)
+		case http.MethodGet:
+			get(w, r, users)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func get(w http.ResponseWriter, r *http.Request, users repo.UserStore) {
+	manager, err := session.NewManager()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s, err := manager.SessionStart(w, r)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if s.UserId() == "" {
+		http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+		return
+	}
+
+	user, err := users.FindById(s.UserId())
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+			return
+		}
+		log.Printf("User Fetch Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	t, _ := template.ParseFS(views.Files, "profile.html")
+	err = t.Execute(w, map[string]interface{}{
+		"user":     user,
+		"userName": user.Name,
+	})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// ユーザー名を変更してセッションのユーザー情報も更新する
+func post(w http.ResponseWriter, r *http.Request, users repo.UserStore) {
+	manager, err := session.NewManager()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s, err := manager.SessionStart(w, r)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if s.UserId() == "" {
+		http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+		return
+	}
+
+	user, err := users.FindById(s.UserId())
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+			return
+		}
+		log.Printf("User Fetch Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	errMsg, hasErr := validate(r)
+	if hasErr {
+		render(w, r, user, errMsg)
+		return
+	}
+
+	err = users.Rename(user.Id, r.FormValue("userName"))
+	if errors.Is(err, repo.ErrDuplicate) {
+		errMsg["userName"] = append(errMsg["userName"], "ユーザー名は既に登録されています")
+		render(w, r, user, errMsg)
+		return
+	}
+	if err != nil {
+		log.Printf("User Rename Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	user.Name = r.FormValue("userName")
+	s.Values["user"] = &repo.User{Id: user.Id, Name: user.Name}
+	if err := s.Save(); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/profile", http.StatusSeeOther)
+}
+
+func render(w http.ResponseWriter, r *http.Request, user *repo.User, errMsg map[string][]string) {
+	t, _ := template.ParseFS(views.Files, "profile.html")
+	err := t.Execute(w, map[string]interface{}{
+		"user":     user,
+		"errMsg":   errMsg,
+		"userName": r.FormValue("userName"),
+	})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+func validate(r *http.Request) (map[string][]string, bool) {
+	errMsg := make(map[string][]string)
+	hasErr := false
+	if r.FormValue("userName") == "" {
+		errMsg["userName"] = append(errMsg["userName"], "ユーザー名は必須です")
+		hasErr = true
+	}
+	return errMsg, hasErr
+}
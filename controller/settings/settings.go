@@ -0,0 +1,158 @@
+package settings
+
+import (
+	"errors"
+	"go-form/core/session"
+	"go-form/repo"
+	views "go-form/template"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+func Settings(users repo.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			post(w, r, users)
+		case http.MethodGet:
+			get(w, r)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func get(w http.ResponseWriter, r *http.Request) {
+	manager, err := session.NewManager()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s, err := manager.SessionStart(w, r)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if s.UserId() == "" {
+		http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+		return
+	}
+
+	render(w, map[string]interface{}{
+		"user": s.Values["user"],
+		"done": r.URL.Query().Get("done") != "",
+	})
+}
+
+/*
+*
+  - action の値で処理を切り替える
+    password: 現在のパスワードを確認してから変更
+    deactivate: アカウントを無効化してログアウト
+    delete: アカウントを論理削除してログアウト
+    purge: アカウントを完全に削除してログアウト
+
+*
+*/
+func post(w http.ResponseWriter, r *http.Request, users repo.UserStore) {
+	manager, err := session.NewManager()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s, err := manager.SessionStart(w, r)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	id := s.UserId()
+	if id == "" {
+		http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "password":
+		changePassword(w, r, users, id, s.Values["user"])
+		return
+	case "deactivate":
+		err = users.Deactivate(id)
+	case "delete":
+		err = users.SoftDelete(id)
+	case "purge":
+		err = users.HardDelete(id)
+	default:
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+			return
+		}
+		log.Printf("User Update Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// パスワード変更以外はアカウントが使えなくなるのでログアウトさせる
+	if err := manager.Destroy(w, r); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// 入力エラーや現在のパスワードの誤りは画面に表示し、変更できたら完了の表示に戻す
+func changePassword(w http.ResponseWriter, r *http.Request, users repo.UserStore, id string, user interface{}) {
+	errMsg, hasErr := validatePassword(r)
+	if hasErr {
+		render(w, map[string]interface{}{
+			"user":   user,
+			"errMsg": errMsg,
+		})
+		return
+	}
+
+	err := users.ChangePassword(id, r.FormValue("current"), r.FormValue("password"))
+	if errors.Is(err, repo.ErrWrongPassword) {
+		errMsg["current"] = append(errMsg["current"], "現在のパスワードが正しくありません")
+		render(w, map[string]interface{}{
+			"user":   user,
+			"errMsg": errMsg,
+		})
+		return
+	}
+	if errors.Is(err, repo.ErrNotFound) {
+		http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Printf("User Update Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/settings?done=1", http.StatusSeeOther)
+}
+
+func validatePassword(r *http.Request) (map[string][]string, bool) {
+	errMsg := make(map[string][]string)
+	hasErr := false
+	if r.FormValue("current") == "" {
+		errMsg["current"] = append(errMsg["current"], "現在のパスワードを入力してください")
+		hasErr = true
+	}
+	if len(r.FormValue("password")) < 8 {
+		errMsg["password"] = append(errMsg["password"], "パスワードは8文字以上で入力してください")
+		hasErr = true
+	}
+	return errMsg, hasErr
+}
+
+func render(w http.ResponseWriter, data map[string]interface{}) {
+	t, _ := template.ParseFS(views.Files, "settings.html")
+	if err := t.Execute(w, data); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
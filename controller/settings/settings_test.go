@@ -0,0 +1,164 @@
+package settings
+
+import (
+	"go-form/controller/signin"
+	"go-form/core/session"
+	"go-form/repo"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "sessions")
+	if err != nil {
+		panic(err)
+	}
+	session.Dir = dir
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// ログイン画面から name でログインし、セッションの Cookie を返す
+func signIn(t *testing.T, users repo.UserStore, name, password string) *http.Cookie {
+	t.Helper()
+	form := url.Values{"userName": {name}, "password": {password}}
+	req := httptest.NewRequest(http.MethodPost, "/sign-in", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	signin.SignIn(users)(rec, req)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == session.SId {
+			return c
+		}
+	}
+	t.Fatalf("sign in as %s: status %d, no session cookie", name, rec.Code)
+	return nil
+}
+
+func postForm(t *testing.T, users repo.UserStore, cookie *http.Cookie, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/settings", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	Settings(users)(rec, req)
+	return rec
+}
+
+func TestSettingsPassword(t *testing.T) {
+	tests := []struct {
+		name         string
+		form         url.Values
+		wantStatus   int
+		wantLocation string
+		wantBody     string
+		wantPassword string // 変更後にログインできるパスワード
+	}{
+		{"changed", url.Values{"current": {"password1"}, "password": {"password2"}},
+			http.StatusSeeOther, "/settings?done=1", "", "password2"},
+		{"wrong current password", url.Values{"current": {"password9"}, "password": {"password2"}},
+			http.StatusOK, "", "現在のパスワードが正しくありません", "password1"},
+		{"missing current password", url.Values{"password": {"password2"}},
+			http.StatusOK, "", "現在のパスワードを入力してください", "password1"},
+		{"short new password", url.Values{"current": {"password1"}, "password": {"short"}},
+			http.StatusOK, "", "パスワードは8文字以上で入力してください", "password1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := repo.NewMemoryUserStore()
+			if _, err := users.Create("alice", "password1"); err != nil {
+				t.Fatal(err)
+			}
+			tt.form.Set("action", "password")
+			rec := postForm(t, users, signIn(t, users, "alice", "password1"), tt.form)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body does not contain %q", tt.wantBody)
+			}
+			if !users.Auth("alice", tt.wantPassword) {
+				t.Errorf("cannot sign in with %q", tt.wantPassword)
+			}
+		})
+	}
+}
+
+func TestSettingsAccount(t *testing.T) {
+	tests := []struct {
+		action       string
+		wantStatus   int
+		wantLocation string
+		wantFound    bool // FindById で見つかる
+		wantAuth     bool
+	}{
+		{"deactivate", http.StatusSeeOther, "/", true, false},
+		{"delete", http.StatusSeeOther, "/", false, false},
+		{"purge", http.StatusSeeOther, "/", false, false},
+		{"unknown", http.StatusBadRequest, "", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			users := repo.NewMemoryUserStore()
+			user, err := users.Create("alice", "password1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := postForm(t, users, signIn(t, users, "alice", "password1"), url.Values{"action": {tt.action}})
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+			if _, err := users.FindById(user.Id); (err == nil) != tt.wantFound {
+				t.Errorf("FindById err = %v, want found = %v", err, tt.wantFound)
+			}
+			if got := users.Auth("alice", "password1"); got != tt.wantAuth {
+				t.Errorf("Auth = %v, want %v", got, tt.wantAuth)
+			}
+		})
+	}
+}
+
+func TestSettingsSignedOut(t *testing.T) {
+	users := repo.NewMemoryUserStore()
+	user, err := users.Create("alice", "password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := signIn(t, users, "alice", "password1")
+	if err := users.SoftDelete(user.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		form   url.Values
+	}{
+		{"no session", nil, url.Values{"action": {"deactivate"}}},
+		{"deleted user changes password", cookie, url.Values{"action": {"password"}, "current": {"password1"}, "password": {"password2"}}},
+		{"deleted user deactivates", cookie, url.Values{"action": {"deactivate"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postForm(t, users, tt.cookie, tt.form)
+			if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/sign-in" {
+				t.Errorf("got %d %q, want redirect to /sign-in", rec.Code, rec.Header().Get("Location"))
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package signin
+
+import (
+	"errors"
+	"go-form/core/session"
+	"go-form/repo"
+	"log"
+	"net/http"
+)
+
+// セッションのユーザーが無効化・削除されていたら、セッションからユーザーを外してから次のハンドラーを呼ぶ
+// 設定画面から無効化や削除をしても、他の端末のセッションはログインしたままになるので、リクエストごとに確認する
+func Middleware(users repo.UserStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		manager, err := session.NewManager()
+		if err != nil {
+			log.Printf("Session Manager Initialization Error: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		s, err := manager.SessionStart(w, r)
+		if err != nil {
+			log.Printf("Session Start Error: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if id := s.UserId(); id != "" {
+			user, err := users.FindById(id)
+			if err != nil && !errors.Is(err, repo.ErrNotFound) {
+				log.Printf("User Fetch Error: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			// 見つからない場合は論理削除か完全に削除されている
+			if err != nil || !user.Active {
+				delete(s.Values, "user")
+				if err := s.Save(); err != nil {
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,106 @@
+package signin
+
+import (
+	"go-form/core/session"
+	"go-form/repo"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "sessions")
+	if err != nil {
+		panic(err)
+	}
+	session.Dir = dir
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// ログイン画面から name でログインし、セッションの Cookie を返す
+func signIn(t *testing.T, users repo.UserStore, name, password string) *http.Cookie {
+	t.Helper()
+	form := url.Values{"userName": {name}, "password": {password}}
+	req := httptest.NewRequest(http.MethodPost, "/sign-in", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	SignIn(users)(rec, req)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == session.SId {
+			return c
+		}
+	}
+	t.Fatalf("sign in as %s: status %d, no session cookie", name, rec.Code)
+	return nil
+}
+
+// Middleware を通したあとにハンドラーから見えるユーザーID
+func userIdAfter(t *testing.T, users repo.UserStore, cookie *http.Cookie) string {
+	t.Helper()
+	var got string
+	handler := Middleware(users, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		manager, err := session.NewManager()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := manager.SessionStart(w, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = s.UserId()
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	return got
+}
+
+func TestMiddlewareSignsOutDisabledUsers(t *testing.T) {
+	tests := []struct {
+		name     string
+		disable  func(users repo.UserStore, id string) error
+		wantUser bool
+	}{
+		{"active", nil, true},
+		{"deactivated", func(users repo.UserStore, id string) error { return users.Deactivate(id) }, false},
+		{"soft deleted", func(users repo.UserStore, id string) error { return users.SoftDelete(id) }, false},
+		{"hard deleted", func(users repo.UserStore, id string) error { return users.HardDelete(id) }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := repo.NewMemoryUserStore()
+			alice, err := users.Create("alice", "password1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 別の端末でログインしたままのセッション
+			other := signIn(t, users, "alice", "password1")
+			if tt.disable != nil {
+				if err := tt.disable(users, alice.Id); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want := ""
+			if tt.wantUser {
+				want = alice.Id
+			}
+			if got := userIdAfter(t, users, other); got != want {
+				t.Errorf("user id = %q, want %q", got, want)
+			}
+			// ユーザーを外したセッションは次のリクエストでもログインしていない
+			if got := userIdAfter(t, users, other); got != want {
+				t.Errorf("user id on the next request = %q, want %q", got, want)
+			}
+		})
+	}
+}
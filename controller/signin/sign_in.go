@@ -2,21 +2,23 @@ package signin
 
 import (
 	"fmt"
-	"go-form/core/database"
 	"go-form/core/session"
 	"go-form/repo"
+	views "go-form/template"
 	"html/template"
 	"net/http"
 )
 
-func SignIn(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodPost:
-		post(w, r)
-	case http.MethodGet:
-		get(w, r)
-	default:
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+func SignIn(users repo.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			post(w, r, users)
+		case http.MethodGet:
+			get(w, r)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
 	}
 }
 
@@ -37,7 +39,7 @@ func get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	t, _ := template.ParseFiles("template/sign_in.html")
+	t, _ := template.ParseFS(views.Files, "sign_in.html")
 	err = t.Execute(w, nil)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -45,14 +47,14 @@ func get(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func post(w http.ResponseWriter, r *http.Request) {
-	errMsg, hasErr, err := validate(r)
+func post(w http.ResponseWriter, r *http.Request, users repo.UserStore) {
+	errMsg, hasErr, err := validate(r, users)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 	if hasErr {
-		t, _ := template.ParseFiles("template/sign_in.html")
+		t, _ := template.ParseFS(views.Files, "sign_in.html")
 		err := t.Execute(w, map[string]interface{}{
 			"errMsg":   errMsg,
 			"userName": r.FormValue("userName"),
@@ -77,10 +79,7 @@ func post(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db := database.DB()
-	defer db.Close()
-	userRepo := repo.NewUserRepository(db)
-	user := userRepo.FindByName(r.FormValue("userName"))
+	user := users.FindByName(r.FormValue("userName"))
 	fmt.Printf("check")
 	s.Values["user"] = user
 	err = s.Save()
@@ -92,7 +91,7 @@ func post(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
-func validate(r *http.Request) (map[string][]string, bool, error) {
+func validate(r *http.Request, users repo.UserStore) (map[string][]string, bool, error) {
 	errMsg := make(map[string][]string)
 	hasErr := false
 	if r.FormValue("userName") == "" {
@@ -108,13 +107,10 @@ func validate(r *http.Request) (map[string][]string, bool, error) {
 		return errMsg, hasErr, nil
 	}
 
-	db := database.DB()
-	defer db.Close()
-	userRepo := repo.NewUserRepository(db)
-	auth := userRepo.Auth(r.FormValue("userName"), r.FormValue("password"))
+	auth := users.Auth(r.FormValue("userName"), r.FormValue("password"))
 	if auth == false {
 		errMsg["password"] = append(errMsg["password"], "ログインに失敗しました")
 		hasErr = true
 	}
-	return errMsg, false, nil
+	return errMsg, hasErr, nil
 }
@@ -1,27 +1,29 @@
 package signup
 
 import (
-	"fmt"
-	"go-form/core/database"
+	"errors"
 	"go-form/core/session"
 	"go-form/repo"
+	views "go-form/template"
 	"html/template"
 	"net/http"
 )
 
-func SignUp(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodPost:
-		post(w, r)
-	case http.MethodGet:
-		get(w)
-	default:
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+func SignUp(users repo.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			post(w, r, users)
+		case http.MethodGet:
+			get(w)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
 	}
 }
 
 func get(w http.ResponseWriter) {
-	t, _ := template.ParseFiles("template/sign_up.html")
+	t, _ := template.ParseFS(views.Files, "sign_up.html")
 	err := t.Execute(w, nil)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -36,42 +38,29 @@ func get(w http.ResponseWriter) {
     1.1 バリデーションエラーの場合はエラーメッセージを表示
     a. ユーザー名とパスワードが空でないか
     b. パスワードが8文字以上か
-    c. ユーザー名が既に登録されていないか
     1.2 バリデーションエラーがない場合は次の処理へ
     2. ユーザー登録
     2.1 パースワードをハッシュ化
     2.2 ユーザー名とパスワードをDBに保存
+    2.2.1 ユーザー名が既に登録されている場合はエラーメッセージを表示
     2.3 認証処理を実行
     3. ホーム画面にリダイレクト
 
 *
 */
-func post(w http.ResponseWriter, r *http.Request) {
-	errMsg, hasErr, err := validate(r)
-	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+func post(w http.ResponseWriter, r *http.Request, users repo.UserStore) {
+	errMsg, hasErr := Validate(r.FormValue("userName"), r.FormValue("password"))
+	if hasErr {
+		render(w, r, errMsg)
 		return
 	}
-
-	if hasErr {
-		t, _ := template.ParseFiles("template/sign_up.html")
-		fmt.Printf("%v", r.Form)
-		err := t.Execute(w, map[string]interface{}{
-			"errMsg":   errMsg,
-			"userName": r.FormValue("userName"),
-			"password": r.FormValue("password"),
-		})
-		if err != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
+	// ユーザー登録。事前に存在確認をすると同時登録で競合するので一意制約の違反で判定する
+	user, err := users.Create(r.FormValue("userName"), r.FormValue("password"))
+	if errors.Is(err, repo.ErrDuplicate) {
+		errMsg["userName"] = append(errMsg["userName"], "ユーザー名は既に登録されています")
+		render(w, r, errMsg)
 		return
 	}
-	db := database.DB()
-	defer db.Close()
-	userRepo := repo.NewUserRepository(db)
-	// ユーザー登録
-	user, err := userRepo.Create(r.FormValue("userName"), r.FormValue("password"))
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -98,11 +87,22 @@ func post(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
-func validate(r *http.Request) (map[string][]string, bool, error) {
+func render(w http.ResponseWriter, r *http.Request, errMsg map[string][]string) {
+	t, _ := template.ParseFS(views.Files, "sign_up.html")
+	err := t.Execute(w, map[string]interface{}{
+		"errMsg":   errMsg,
+		"userName": r.FormValue("userName"),
+		"password": r.FormValue("password"),
+	})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// ユーザー名とパスワードを検証する。ユーザーの一括登録でも同じ規則を使う
+func Validate(userName, password string) (map[string][]string, bool) {
 	errMsg := make(map[string][]string)
 	hasErr := false
-	userName := r.FormValue("userName")
-	password := r.FormValue("password")
 	if userName == "" {
 		errMsg["userName"] = append(errMsg["userName"], "ユーザー名は必須です")
 		hasErr = true
@@ -117,18 +117,5 @@ func validate(r *http.Request) (map[string][]string, bool, error) {
 		hasErr = true
 	}
 
-	db := database.DB()
-	defer db.Close()
-	userRepo := repo.NewUserRepository(db)
-	exists, err := userRepo.Exists(userName)
-	if err != nil {
-		return errMsg, hasErr, err
-	}
-
-	if exists {
-		errMsg["userName"] = append(errMsg["userName"], "ユーザー名は既に登録されています")
-		hasErr = true
-	}
-
-	return errMsg, hasErr, nil
+	return errMsg, hasErr
 }
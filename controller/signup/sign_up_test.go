@@ -0,0 +1,83 @@
+package signup
+
+import (
+	"go-form/core/session"
+	"go-form/repo"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "sessions")
+	if err != nil {
+		panic(err)
+	}
+	session.Dir = dir
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestSignUp(t *testing.T) {
+	tests := []struct {
+		name         string
+		form         url.Values
+		wantStatus   int
+		wantLocation string
+		wantBody     string
+		wantUser     bool
+	}{
+		{"registered", url.Values{"userName": {"carol"}, "password": {"password1"}}, http.StatusSeeOther, "/home", "", true},
+		{"short password", url.Values{"userName": {"carol"}, "password": {"short"}}, http.StatusOK, "", "パスワードは8文字以上", false},
+		{"empty name", url.Values{"password": {"password1"}}, http.StatusOK, "", "ユーザー名は必須です", false},
+		{"taken name", url.Values{"userName": {"alice"}, "password": {"password1"}}, http.StatusOK, "", "ユーザー名は既に登録されています", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := repo.NewMemoryUserStore()
+			if _, err := users.Create("alice", "password1"); err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/sign-up", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			SignUp(users)(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body does not contain %q", tt.wantBody)
+			}
+			user := users.FindByName("carol")
+			if (user != nil) != tt.wantUser {
+				t.Fatalf("user created = %v, want %v", user != nil, tt.wantUser)
+			}
+			if user == nil {
+				return
+			}
+			// 登録したユーザーは一般ユーザー。管理者には起動時の ADMIN_USERS でだけなる
+			if got, _ := users.FindById(user.Id); got.Role != repo.RoleUser {
+				t.Errorf("role = %q, want user", got.Role)
+			}
+			if !users.Auth("carol", "password1") {
+				t.Error("cannot sign in with the registered password")
+			}
+		})
+	}
+}
+
+func TestSignUpMethodNotAllowed(t *testing.T) {
+	rec := httptest.NewRecorder()
+	SignUp(repo.NewMemoryUserStore())(rec, httptest.NewRequest(http.MethodDelete, "/sign-up", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
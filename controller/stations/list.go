@@ -0,0 +1,120 @@
+package stations
+
+import (
+	"errors"
+	"go-form/core/session"
+	"go-form/repo"
+	views "go-form/template"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+func List(stations repo.WeatherStationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			getList(w, r, stations)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func getList(w http.ResponseWriter, r *http.Request, stations repo.WeatherStationStore) {
+	// セッションマネージャの初期化
+	manager, err := session.NewManager()
+	if err != nil {
+		log.Printf("Session Manager Initialization Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// セッション開始
+	s, err := manager.SessionStart(w, r)
+	if err != nil {
+		log.Printf("Session Start Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	user := s.Values["user"]
+	if user == nil {
+		http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+		return
+	}
+
+	q := r.URL.Query()
+	opts, err := listOptions(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := stations.List(opts)
+	if err != nil {
+		if errors.Is(err, repo.ErrInvalidSort) || errors.Is(err, repo.ErrInvalidCursor) || errors.Is(err, repo.ErrInvalidImport) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Data Fetch Error: %v", err)
+		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		return
+	}
+
+	var next string
+	if page.Next != "" {
+		q.Set("cursor", page.Next)
+		next = "/stations?" + q.Encode()
+	}
+
+	t, _ := template.ParseFS(views.Files, "stations.html")
+	err = t.Execute(w, map[string]interface{}{
+		"user":     user,
+		"stations": page.Items,
+		"next":     next,
+		"city":     q.Get("city"),
+		"min":      q.Get("min"),
+		"max":      q.Get("max"),
+		"sort":     opts.Sort,
+		"desc":     opts.Desc,
+	})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// クエリパラメータから一覧取得の条件を組み立てる
+func listOptions(q url.Values) (repo.ListOptions, error) {
+	opts := repo.ListOptions{
+		Sort:   q.Get("sort"),
+		Desc:   q.Get("order") == "desc",
+		Cursor: q.Get("cursor"),
+		City:   q.Get("city"),
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, errors.New("limit must be an integer")
+		}
+		opts.Limit = limit
+	}
+	if v := q.Get("min"); v != "" {
+		minTemp, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return opts, errors.New("min must be a number")
+		}
+		opts.MinTemperature = &minTemp
+	}
+	if v := q.Get("max"); v != "" {
+		maxTemp, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return opts, errors.New("max must be a number")
+		}
+		opts.MaxTemperature = &maxTemp
+	}
+	return opts, nil
+}
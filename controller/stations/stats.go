@@ -0,0 +1,134 @@
+package stations
+
+import (
+	"errors"
+	"go-form/core/export"
+	"go-form/core/session"
+	"go-form/repo"
+	views "go-form/template"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func Stats(stations repo.WeatherStationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			getStats(w, r, stations)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func getStats(w http.ResponseWriter, r *http.Request, stations repo.WeatherStationStore) {
+	// セッションマネージャの初期化
+	manager, err := session.NewManager()
+	if err != nil {
+		log.Printf("Session Manager Initialization Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// セッション開始
+	s, err := manager.SessionStart(w, r)
+	if err != nil {
+		log.Printf("Session Start Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	user := s.Values["user"]
+	if user == nil {
+		http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+		return
+	}
+
+	filter := repo.StatsFilter{
+		CityPrefix: r.URL.Query().Get("prefix"),
+		ImportId:   r.URL.Query().Get("import"),
+	}
+	stats, err := stations.Stats(filter)
+	if err != nil {
+		if errors.Is(err, repo.ErrInvalidImport) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Stats Fetch Error: %v", err)
+		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		return
+	}
+
+	// ブラウザからは画面、それ以外は format か Accept ヘッダーで選んだ形式で返す
+	format, err := export.Negotiate(r, append([]export.Format{export.Html}, export.Formats...)...)
+	if err != nil {
+		export.WriteError(w, err)
+		return
+	}
+	if format != export.Html {
+		columns, err := repo.CityStatExportColumns(export.ColumnNames(r.URL.Query()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if v := r.URL.Query().Get("limit"); v != "" {
+			limit, err := strconv.Atoi(v)
+			if err != nil || limit < 0 {
+				http.Error(w, "limit must be a non-negative integer", http.StatusBadRequest)
+				return
+			}
+			if limit > 0 && limit < len(stats) {
+				stats = stats[:limit]
+			}
+		}
+		export.Write(w, r, format, statsTable(stats, columns))
+		return
+	}
+
+	t, _ := template.ParseFS(views.Files, "stations_stats.html")
+	err = t.Execute(w, map[string]interface{}{
+		"user":      user,
+		"prefix":    filter.CityPrefix,
+		"importId":  filter.ImportId,
+		"stats":     stats,
+		"canonical": canonical(stats),
+		"formats":   export.Formats,
+	})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// {City=min/mean/max, ...} 形式の文字列を組み立てる
+func canonical(stats []repo.CityStat) string {
+	parts := make([]string, 0, len(stats))
+	for _, s := range stats {
+		parts = append(parts, s.String())
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// 最小・平均・最大は 1BRC と同じく小数点以下1桁で書き出す
+func statsTable(stats []repo.CityStat, columns repo.Columns[repo.CityStat]) export.Table {
+	return export.Table{
+		Name:    "stations_stats",
+		Columns: columns.Names(),
+		Rows: func(yield func([]any, error) bool) {
+			for _, s := range stats {
+				row := columns.Values(s)
+				for i, v := range row {
+					if f, ok := v.(float64); ok {
+						row[i] = export.Fixed{Value: f, Digits: 1}
+					}
+				}
+				if !yield(row, nil) {
+					return
+				}
+			}
+		},
+	}
+}
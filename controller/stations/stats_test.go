@@ -0,0 +1,88 @@
+package stations
+
+import (
+	"go-form/controller/signin"
+	"go-form/core/session"
+	"go-form/repo"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "sessions")
+	if err != nil {
+		panic(err)
+	}
+	session.Dir = dir
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// alice を登録してログインし、セッションの Cookie を返す
+func signIn(t *testing.T) *http.Cookie {
+	t.Helper()
+	users := repo.NewMemoryUserStore()
+	if _, err := users.Create("alice", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"userName": {"alice"}, "password": {"password1"}}
+	req := httptest.NewRequest(http.MethodPost, "/sign-in", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	signin.SignIn(users)(rec, req)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == session.SId {
+			return c
+		}
+	}
+	t.Fatalf("sign in: status %d, no session cookie", rec.Code)
+	return nil
+}
+
+func TestStats(t *testing.T) {
+	stations := repo.NewMemoryWeatherStationStore()
+	importId := repo.NewImportId()
+	measuredAt := time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+	if _, err := stations.Import(repo.ModeAppend, []repo.WeatherStation{
+		{City: "Tokyo", Temperature: 10, MeasuredAt: measuredAt, ImportId: importId},
+		{City: "Tokyo", Temperature: 14, MeasuredAt: measuredAt.Add(time.Hour), ImportId: importId},
+		{City: "Osaka", Temperature: 20, MeasuredAt: measuredAt},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cookie := signIn(t)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantBody   string
+	}{
+		{"all", "", http.StatusOK, "{Osaka=20.0/20.0/20.0, Tokyo=10.0/12.0/14.0}"},
+		{"city prefix", "?prefix=Tok", http.StatusOK, "{Tokyo=10.0/12.0/14.0}"},
+		{"one import", "?import=" + importId, http.StatusOK, "{Tokyo=10.0/12.0/14.0}"},
+		{"malformed import", "?import=abc", http.StatusBadRequest, "invalid import id"},
+		{"malformed import as csv", "?import=abc&format=csv", http.StatusBadRequest, "invalid import id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/stations/stats"+tt.query, nil)
+			req.AddCookie(cookie)
+			rec := httptest.NewRecorder()
+			Stats(stations)(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body does not contain %q:\n%s", tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package users
+
+import (
+	"errors"
+	"go-form/core/export"
+	"go-form/core/session"
+	"go-form/repo"
+	"log"
+	"net/http"
+)
+
+// ユーザー一覧を書き出す。形式はクエリの format か Accept ヘッダーで選び、既定は CSV
+// columns と一覧と同じ name, from, to, sort, order, limit で列と行を絞り込める
+func Export(users repo.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			getExport(w, r, users)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func getExport(w http.ResponseWriter, r *http.Request, users repo.UserStore) {
+	// セッションマネージャの初期化
+	manager, err := session.NewManager()
+	if err != nil {
+		log.Printf("Session Manager Initialization Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// セッション開始
+	s, err := manager.SessionStart(w, r)
+	if err != nil {
+		log.Printf("Session Start Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	user := s.Values["user"]
+	if user == nil {
+		http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+		return
+	}
+
+	format, err := export.Negotiate(r, export.Formats...)
+	if err != nil {
+		export.WriteError(w, err)
+		return
+	}
+	q := r.URL.Query()
+	columns, err := repo.UserExportColumns(export.ColumnNames(q))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := listOptions(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// 書き出しではページに分けず、limit がなければすべての行
+	if opts.Limit < 0 {
+		http.Error(w, "limit must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+	opts.Cursor = ""
+	rows, err := users.Export(repo.ExportOptions{ListOptions: opts})
+	if err != nil {
+		if errors.Is(err, repo.ErrInvalidSort) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Data Fetch Error: %v", err)
+		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		return
+	}
+
+	// xlsx では登録日時は日付のセルになる
+	export.Write(w, r, format, export.Table{
+		Name:    "users",
+		Columns: columns.Names(),
+		Rows:    export.Rows(rows, columns.Values),
+	})
+}
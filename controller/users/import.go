@@ -0,0 +1,307 @@
+package users
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"go-form/controller/signup"
+	"go-form/core/charset"
+	"go-form/core/session"
+	"go-form/repo"
+	views "go-form/template"
+	"html/template"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// 一括登録で受け付けるファイルの大きさと行数の上限
+const (
+	maxImportBytes = 1 << 20
+	maxImportRows  = 1000
+)
+
+// 自動で作るパスワードの長さと使う文字
+const (
+	generatedPasswordLength = 16
+	passwordAlphabet        = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz23456789"
+)
+
+// 一括登録の1行分の結果
+type importRow struct {
+	Line      int
+	Name      string
+	Password  string
+	Role      repo.Role
+	Generated bool     // パスワードを自動で作った
+	Errors    []string // 空なら登録できた
+}
+
+func (row importRow) Created() bool {
+	return len(row.Errors) == 0
+}
+
+// 管理者だけが使える、CSV からのユーザー一括登録
+// 1行目は見出しで name 列が必須、password と role 列は省略できる
+func Import(users repo.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			postImport(w, r, users)
+		case http.MethodGet:
+			getImport(w, r, users)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func getImport(w http.ResponseWriter, r *http.Request, users repo.UserStore) {
+	if _, ok := admin(w, r, users); !ok {
+		return
+	}
+	renderImport(w, map[string]interface{}{})
+}
+
+func postImport(w http.ResponseWriter, r *http.Request, users repo.UserStore) {
+	if _, ok := admin(w, r, users); !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	file, _, err := r.FormFile("csvfile")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			renderImport(w, map[string]interface{}{"error": fmt.Sprintf("ファイルは%dKBまでです", maxImportBytes>>10)})
+			return
+		}
+		renderImport(w, map[string]interface{}{"error": "CSVファイルを選択してください"})
+		return
+	}
+	defer file.Close()
+
+	rows, err := readImportRows(file)
+	if err != nil {
+		renderImport(w, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	createUsers(users, rows)
+
+	created := 0
+	for _, row := range rows {
+		if row.Created() {
+			created++
+		}
+	}
+	credentials, err := credentialsCsv(rows)
+	if err != nil {
+		log.Printf("Credentials Write Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	renderImport(w, map[string]interface{}{
+		"rows":        rows,
+		"created":     created,
+		"failed":      len(rows) - created,
+		"credentials": credentials,
+	})
+}
+
+// セッションのユーザーが管理者か確かめる。違う場合はレスポンスを書いて false を返す
+func admin(w http.ResponseWriter, r *http.Request, users repo.UserStore) (*repo.User, bool) {
+	manager, err := session.NewManager()
+	if err != nil {
+		log.Printf("Session Manager Initialization Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	s, err := manager.SessionStart(w, r)
+	if err != nil {
+		log.Printf("Session Start Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if s.UserId() == "" {
+		http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+		return nil, false
+	}
+
+	user, err := users.FindById(s.UserId())
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+			return nil, false
+		}
+		log.Printf("User Fetch Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !user.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
+
+// CSV を読んで行ごとに検証する。ファイル全体が読めない場合だけエラーを返す
+func readImportRows(file io.Reader) ([]importRow, error) {
+	body, _, err := charset.NewReader(file, charset.Auto)
+	if err != nil {
+		return nil, errors.New("ファイルを読み込めませんでした")
+	}
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("見出し行がありません")
+	}
+	col := map[string]int{"name": -1, "password": -1, "role": -1}
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if idx, ok := col[h]; ok && idx < 0 {
+			col[h] = i
+		}
+	}
+	if col["name"] < 0 {
+		return nil, errors.New("見出し行に name 列がありません")
+	}
+	field := func(record []string, name string) string {
+		if i := col[name]; i >= 0 && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var rows []importRow
+	seen := make(map[string]int)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return nil, fmt.Errorf("%d行目を読み込めませんでした: %v", parseErr.Line, parseErr.Err)
+			}
+			return nil, errors.New("ファイルを読み込めませんでした")
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("一度に登録できるのは%d人までです", maxImportRows)
+		}
+
+		line, _ := reader.FieldPos(0)
+		row := importRow{Line: line, Name: field(record, "name"), Password: field(record, "password")}
+		if row.Password == "" {
+			row.Password, err = generatePassword()
+			if err != nil {
+				return nil, err
+			}
+			row.Generated = true
+		}
+		if errMsg, hasErr := signup.Validate(row.Name, row.Password); hasErr {
+			row.Errors = append(row.Errors, errMsg["userName"]...)
+			row.Errors = append(row.Errors, errMsg["password"]...)
+		}
+		row.Role, err = repo.ParseRole(field(record, "role"))
+		if err != nil {
+			row.Errors = append(row.Errors, "権限は user か admin を指定してください")
+		}
+		if first, ok := seen[row.Name]; ok && row.Name != "" {
+			row.Errors = append(row.Errors, fmt.Sprintf("%d行目と同じユーザー名です", first))
+		} else {
+			seen[row.Name] = line
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("登録するユーザーがありません")
+	}
+	return rows, nil
+}
+
+// 検証を通った行を登録して結果を行に書き込む。パスワードのハッシュ化が重いので同時に登録する数を CPU 数で抑える
+func createUsers(users repo.UserStore, rows []importRow) {
+	sem := make(chan struct{}, max(runtime.GOMAXPROCS(0)/2, 1))
+	var wg sync.WaitGroup
+	for i := range rows {
+		if !rows[i].Created() {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(row *importRow) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			_, err := users.CreateWithRole(row.Name, row.Password, row.Role)
+			if errors.Is(err, repo.ErrDuplicate) {
+				row.Errors = append(row.Errors, "ユーザー名は既に登録されています")
+				return
+			}
+			if err != nil {
+				log.Printf("User Import Error (line %d): %v", row.Line, err)
+				row.Errors = append(row.Errors, "登録に失敗しました")
+			}
+		}(&rows[i])
+	}
+	wg.Wait()
+}
+
+func generatePassword() (string, error) {
+	b := make([]byte, generatedPasswordLength)
+	n := big.NewInt(int64(len(passwordAlphabet)))
+	for i := range b {
+		k, err := rand.Int(rand.Reader, n)
+		if err != nil {
+			return "", err
+		}
+		b[i] = passwordAlphabet[k.Int64()]
+	}
+	return string(b), nil
+}
+
+// 自動で作ったパスワードを知らせるための CSV。サーバーには保存せず data URL でそのまま渡す
+func credentialsCsv(rows []importRow) (template.URL, error) {
+	generated := slices.ContainsFunc(rows, func(row importRow) bool {
+		return row.Created() && row.Generated
+	})
+	if !generated {
+		return "", nil
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"name", "password", "role"})
+	for _, row := range rows {
+		if row.Created() && row.Generated {
+			w.Write([]string{row.Name, row.Password, string(row.Role)})
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+	return template.URL("data:text/csv;charset=utf-8;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+func renderImport(w http.ResponseWriter, data map[string]interface{}) {
+	data["roles"] = repo.Roles
+	t, _ := template.ParseFS(views.Files, "users_import.html")
+	err := t.Execute(w, data)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
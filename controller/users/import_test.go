@@ -0,0 +1,119 @@
+package users
+
+import (
+	"bytes"
+	"go-form/repo"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 管理者、無効化した管理者、一般ユーザーを登録してそれぞれのセッションの Cookie を返す
+func signedIn(t *testing.T, users *repo.MemoryUserStore) map[string]*http.Cookie {
+	t.Helper()
+	cookies := map[string]*http.Cookie{"signed out": nil}
+	for _, who := range []struct {
+		name   string
+		role   repo.Role
+		active bool
+	}{
+		{"admin", repo.RoleAdmin, true},
+		{"deactivated admin", repo.RoleAdmin, false},
+		{"user", repo.RoleUser, true},
+	} {
+		user, err := users.CreateWithRole(who.name, "password1", who.role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cookies[who.name] = signIn(t, users, who.name, "password1")
+		// ログインしたあとに無効化されたセッション
+		if !who.active {
+			if err := users.Deactivate(user.Id); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return cookies
+}
+
+func TestImportRequiresActiveAdmin(t *testing.T) {
+	users := repo.NewMemoryUserStore()
+	cookies := signedIn(t, users)
+
+	tests := []struct {
+		who          string
+		wantStatus   int
+		wantLocation string
+	}{
+		{"signed out", http.StatusSeeOther, "/sign-in"},
+		{"user", http.StatusForbidden, ""},
+		{"deactivated admin", http.StatusForbidden, ""},
+		{"admin", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.who, func(t *testing.T) {
+			for _, method := range []string{http.MethodGet, http.MethodPost} {
+				req := httptest.NewRequest(method, "/users/import", nil)
+				if c := cookies[tt.who]; c != nil {
+					req.AddCookie(c)
+				}
+				rec := httptest.NewRecorder()
+				Import(users)(rec, req)
+				if rec.Code != tt.wantStatus {
+					t.Errorf("%s: status = %d, want %d", method, rec.Code, tt.wantStatus)
+				}
+				if got := rec.Header().Get("Location"); got != tt.wantLocation {
+					t.Errorf("%s: Location = %q, want %q", method, got, tt.wantLocation)
+				}
+			}
+		})
+	}
+}
+
+func TestImportCreatesUsers(t *testing.T) {
+	users := repo.NewMemoryUserStore()
+	cookies := signedIn(t, users)
+
+	csv := "name,password,role\n" +
+		"dave,password1,\n" +
+		"erin,,admin\n" +
+		"user,password1,\n" + // 登録済み
+		"frank,short,\n" +
+		"dave,password2,\n" // ファイル内で重複
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("csvfile", "users.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte(csv))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/users/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.AddCookie(cookies["admin"])
+	rec := httptest.NewRecorder()
+	Import(users)(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "登録：2件 / 失敗：3件") {
+		t.Errorf("body does not report 2 created and 3 failed:\n%s", rec.Body.String())
+	}
+	if !users.Auth("dave", "password1") {
+		t.Error("dave cannot sign in with the given password")
+	}
+	erin := users.FindByName("erin")
+	if erin == nil {
+		t.Fatal("erin was not created")
+	}
+	if got, _ := users.FindById(erin.Id); got.Role != repo.RoleAdmin {
+		t.Errorf("erin role = %q, want admin", got.Role)
+	}
+	if users.FindByName("frank") != nil {
+		t.Error("frank was created with a short password")
+	}
+}
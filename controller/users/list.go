@@ -0,0 +1,147 @@
+package users
+
+import (
+	"errors"
+	"go-form/core/charset"
+	"go-form/core/export"
+	"go-form/core/session"
+	"go-form/repo"
+	views "go-form/template"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+func List(users repo.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			getList(w, r, users)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func getList(w http.ResponseWriter, r *http.Request, users repo.UserStore) {
+	// セッションマネージャの初期化
+	manager, err := session.NewManager()
+	if err != nil {
+		log.Printf("Session Manager Initialization Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// セッション開始
+	s, err := manager.SessionStart(w, r)
+	if err != nil {
+		log.Printf("Session Start Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	user := s.Values["user"]
+	if user == nil {
+		http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+		return
+	}
+
+	// 一括登録と復元は管理者にだけ表示する。権限はセッションではなく現在の値で判定する
+	current, err := users.FindById(s.UserId())
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			http.Redirect(w, r, "/sign-in", http.StatusSeeOther)
+			return
+		}
+		log.Printf("User Fetch Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	q := r.URL.Query()
+	opts, err := listOptions(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// 論理削除したユーザーは復元するために管理者だけが一覧で見られる
+	opts.Deleted = q.Get("deleted") != ""
+	if opts.Deleted && !current.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	page, err := users.List(opts)
+	if err != nil {
+		if errors.Is(err, repo.ErrInvalidSort) || errors.Is(err, repo.ErrInvalidCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Data Fetch Error: %v", err)
+		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		return
+	}
+
+	var next string
+	if page.Next != "" {
+		q.Set("cursor", page.Next)
+		next = "/users?" + q.Encode()
+	}
+
+	t, _ := template.ParseFS(views.Files, "users.html")
+	err = t.Execute(w, map[string]interface{}{
+		"user":            user,
+		"admin":           current.IsAdmin(),
+		"deleted":         opts.Deleted,
+		"users":           page.Items,
+		"next":            next,
+		"name":            q.Get("name"),
+		"from":            q.Get("from"),
+		"to":              q.Get("to"),
+		"sort":            opts.Sort,
+		"desc":            opts.Desc,
+		"exportEncodings": charset.ExportEncodings,
+		"formats":         export.Formats,
+		"columns":         repo.UserColumnNames,
+	})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// クエリパラメータから一覧取得の条件を組み立てる
+func listOptions(q url.Values) (repo.ListOptions, error) {
+	opts := repo.ListOptions{
+		Sort:         q.Get("sort"),
+		Desc:         q.Get("order") == "desc",
+		Cursor:       q.Get("cursor"),
+		NameContains: q.Get("name"),
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, errors.New("limit must be an integer")
+		}
+		opts.Limit = limit
+	}
+	if v := q.Get("from"); v != "" {
+		from, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			return opts, errors.New("from must be YYYY-MM-DD")
+		}
+		opts.CreatedFrom = from
+	}
+	if v := q.Get("to"); v != "" {
+		to, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			return opts, errors.New("to must be YYYY-MM-DD")
+		}
+		// 終了日はその日を含める
+		opts.CreatedTo = to.AddDate(0, 0, 1)
+	}
+	return opts, nil
+}
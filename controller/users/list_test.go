@@ -0,0 +1,37 @@
+package users
+
+import (
+	"go-form/repo"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestListShowsImportLinkToAdmins(t *testing.T) {
+	users := repo.NewMemoryUserStore()
+	cookies := signedIn(t, users)
+
+	tests := []struct {
+		who      string
+		wantLink bool
+	}{
+		{"admin", true},
+		{"deactivated admin", false},
+		{"user", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.who, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/users", nil)
+			req.AddCookie(cookies[tt.who])
+			rec := httptest.NewRecorder()
+			List(users)(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			if got := strings.Contains(rec.Body.String(), `href="/users/import"`); got != tt.wantLink {
+				t.Errorf("import link shown = %v, want %v", got, tt.wantLink)
+			}
+		})
+	}
+}
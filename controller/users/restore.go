@@ -0,0 +1,39 @@
+package users
+
+import (
+	"errors"
+	"go-form/repo"
+	"log"
+	"net/http"
+)
+
+// 論理削除したユーザーを元に戻す。管理者だけが使える
+func Restore(users repo.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			postRestore(w, r, users)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func postRestore(w http.ResponseWriter, r *http.Request, users repo.UserStore) {
+	if _, ok := admin(w, r, users); !ok {
+		return
+	}
+
+	err := users.Restore(r.PathValue("id"))
+	if err != nil {
+		// 論理削除されていないユーザーも見つからない扱いにする
+		if errors.Is(err, repo.ErrNotFound) {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		log.Printf("User Restore Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/users?deleted=1", http.StatusSeeOther)
+}
@@ -0,0 +1,112 @@
+package users
+
+import (
+	"go-form/controller/signin"
+	"go-form/core/session"
+	"go-form/repo"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "sessions")
+	if err != nil {
+		panic(err)
+	}
+	session.Dir = dir
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// ログイン画面から name でログインし、セッションの Cookie を返す。ログインできない場合は nil
+func signIn(t *testing.T, users repo.UserStore, name, password string) *http.Cookie {
+	t.Helper()
+	form := url.Values{"userName": {name}, "password": {password}}
+	req := httptest.NewRequest(http.MethodPost, "/sign-in", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	signin.SignIn(users)(rec, req)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == session.SId {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestRestore(t *testing.T) {
+	users := repo.NewMemoryUserStore()
+	if _, err := users.CreateWithRole("admin", "password1", repo.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	alice, err := users.Create("alice", "password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Create("bob", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SoftDelete(alice.Id); err != nil {
+		t.Fatal(err)
+	}
+	if signIn(t, users, "alice", "password1") != nil {
+		t.Fatal("signed in as a soft deleted user")
+	}
+	admin := signIn(t, users, "admin", "password1")
+	bob := signIn(t, users, "bob", "password1")
+
+	// 削除したユーザーの一覧は管理者だけが見られる
+	list := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/users?deleted=1", nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		List(users)(rec, req)
+		return rec
+	}
+	if rec := list(bob); rec.Code != http.StatusForbidden {
+		t.Errorf("deleted users as user: status = %d, want 403", rec.Code)
+	}
+	rec := list(admin)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("deleted users as admin: status = %d, want 200", rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `action="/users/`+alice.Id+`/restore"`) || strings.Contains(body, "bob") {
+		t.Errorf("deleted users list should show only alice:\n%s", body)
+	}
+
+	restore := func(cookie *http.Cookie, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/users/"+id+"/restore", nil)
+		req.SetPathValue("id", id)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		Restore(users)(rec, req)
+		return rec
+	}
+	if rec := restore(bob, alice.Id); rec.Code != http.StatusForbidden {
+		t.Errorf("restore as user: status = %d, want 403", rec.Code)
+	}
+	rec = restore(admin, alice.Id)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("restore: status = %d, want 303", rec.Code)
+	}
+	if got := rec.Header().Get("Location"); got != "/users?deleted=1" {
+		t.Errorf("Location = %q", got)
+	}
+	// 論理削除されていないユーザーは復元できない
+	if rec := restore(admin, alice.Id); rec.Code != http.StatusNotFound {
+		t.Errorf("restore twice: status = %d, want 404", rec.Code)
+	}
+
+	// 復元したユーザーは元のパスワードでログインできる
+	if signIn(t, users, "alice", "password1") == nil {
+		t.Error("cannot sign in after restore")
+	}
+	if got, err := users.FindById(alice.Id); err != nil || got.DeletedAt != nil {
+		t.Errorf("FindById after restore = %+v, %v", got, err)
+	}
+}
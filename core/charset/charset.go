@@ -0,0 +1,182 @@
+package charset
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/transform"
+)
+
+// CSV の文字コード
+type Encoding string
+
+const (
+	Auto     Encoding = "auto"      // 読み込み時に自動判定する
+	UTF8     Encoding = "utf-8"     // BOM があれば読み飛ばす
+	UTF8BOM  Encoding = "utf-8-bom" // 書き込み時に BOM を付ける。Excel で開く場合に使う
+	ShiftJIS Encoding = "shift_jis"
+	EUCJP    Encoding = "euc-jp"
+)
+
+// アップロードで選べる文字コード
+var ImportEncodings = []Encoding{Auto, UTF8, ShiftJIS, EUCJP}
+
+// ダウンロードで選べる文字コード
+var ExportEncodings = []Encoding{UTF8, UTF8BOM, ShiftJIS, EUCJP}
+
+func (e Encoding) Label() string {
+	switch e {
+	case Auto:
+		return "自動判定"
+	case UTF8:
+		return "UTF-8"
+	case UTF8BOM:
+		return "UTF-8 (BOM付き)"
+	case ShiftJIS:
+		return "Shift_JIS"
+	case EUCJP:
+		return "EUC-JP"
+	}
+	return string(e)
+}
+
+// Content-Type の charset に使う名前
+func (e Encoding) Charset() string {
+	switch e {
+	case ShiftJIS:
+		return "Shift_JIS"
+	case EUCJP:
+		return "EUC-JP"
+	}
+	return "UTF-8"
+}
+
+// 空の場合は def を返す
+func Parse(s string, def Encoding) (Encoding, error) {
+	if s == "" {
+		return def, nil
+	}
+	switch e := Encoding(s); e {
+	case Auto, UTF8, UTF8BOM, ShiftJIS, EUCJP:
+		return e, nil
+	}
+	return "", fmt.Errorf("unknown encoding %q", s)
+}
+
+var bom = []byte{0xEF, 0xBB, 0xBF}
+
+// 判定に使う先頭のバイト数
+const sniffSize = 64 * 1024
+
+// r を UTF-8 に変換して読む Reader を返す。Auto の場合は先頭を読んで判定し、判定した文字コードも返す
+// UTF-8 の BOM は取り除く
+func NewReader(r io.Reader, enc Encoding) (io.Reader, Encoding, error) {
+	br := bufio.NewReaderSize(r, sniffSize)
+	head, err := br.Peek(sniffSize)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, "", err
+	}
+	atEOF := len(head) < sniffSize
+
+	if enc == Auto || enc == UTF8 || enc == UTF8BOM {
+		if bytes.HasPrefix(head, bom) {
+			br.Discard(len(bom))
+			return br, UTF8, nil
+		}
+	}
+	if enc == Auto {
+		enc = Detect(head, atEOF)
+	}
+
+	switch enc {
+	case UTF8, UTF8BOM:
+		return br, UTF8, nil
+	case ShiftJIS:
+		return transform.NewReader(br, japanese.ShiftJIS.NewDecoder()), enc, nil
+	case EUCJP:
+		return transform.NewReader(br, japanese.EUCJP.NewDecoder()), enc, nil
+	}
+	return nil, "", fmt.Errorf("unknown encoding %q", enc)
+}
+
+// 先頭のバイト列から文字コードを推定する。atEOF が false の場合は末尾の途中で切れた文字を無視する
+// UTF-8 として正しければ UTF-8、そうでなければ Shift_JIS と EUC-JP で変換して不正なバイトが少ない方を選ぶ
+func Detect(head []byte, atEOF bool) Encoding {
+	if bytes.HasPrefix(head, bom) {
+		return UTF8
+	}
+	if !atEOF {
+		head = trimIncomplete(head)
+	}
+	if utf8.Valid(head) {
+		return UTF8
+	}
+	if score(head, japanese.EUCJP) < score(head, japanese.ShiftJIS) {
+		return EUCJP
+	}
+	return ShiftJIS
+}
+
+// 変換できなかった文字と半角カナの数。EUC-JP の2バイト文字は Shift_JIS として読むと半角カナになりやすいので、少ないほどそれらしい
+func score(b []byte, enc encoding.Encoding) int {
+	decoded, _, err := transform.Bytes(enc.NewDecoder(), b)
+	if err != nil {
+		return len(b) * 10
+	}
+	n := 0
+	for _, r := range string(decoded) {
+		switch {
+		case r == utf8.RuneError:
+			n += 10
+		case r >= 0xFF61 && r <= 0xFF9F:
+			n++
+		}
+	}
+	return n
+}
+
+// 末尾の途中で切れたマルチバイト文字を取り除く
+func trimIncomplete(b []byte) []byte {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i]
+			}
+			break
+		}
+	}
+	return b
+}
+
+// w に enc で書き込む Writer を返す。変換後のバイト列を出し切るため、書き込み後に必ず Close すること
+// 変換できない文字は ? などの代替文字にする
+func NewWriter(w io.Writer, enc Encoding) (io.WriteCloser, error) {
+	switch enc {
+	case UTF8:
+		return nopCloser{w}, nil
+	case UTF8BOM:
+		if _, err := w.Write(bom); err != nil {
+			return nil, err
+		}
+		return nopCloser{w}, nil
+	case ShiftJIS:
+		return transform.NewWriter(w, encoding.ReplaceUnsupported(japanese.ShiftJIS.NewEncoder())), nil
+	case EUCJP:
+		return transform.NewWriter(w, encoding.ReplaceUnsupported(japanese.EUCJP.NewEncoder())), nil
+	}
+	return nil, fmt.Errorf("unknown encoding %q", enc)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
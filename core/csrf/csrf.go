@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"go-form/core/session"
+	"mime"
 	"net/http"
 )
 
@@ -22,7 +23,14 @@ func generate() (string, error) {
 
 func validate(r *http.Request, sessionToken string) error {
 	// リクエストからトークンを取得
-	requestToken := r.FormValue(name) // フォームやクエリから取得
+	// multipart の本文は ParseMultipartForm で全体を読み込んでしまうので、ヘッダーかクエリから取得する
+	// （アップロードの本文はハンドラーがストリーミングで読む）
+	requestToken := r.Header.Get("X-CSRF-Token")
+	if requestToken == "" && isMultipart(r) {
+		requestToken = r.URL.Query().Get(name)
+	} else if requestToken == "" {
+		requestToken = r.FormValue(name) // フォームやクエリから取得
+	}
 	if requestToken == "" {
 		cookie, err := r.Cookie(name)
 		if err != nil {
@@ -38,6 +46,11 @@ func validate(r *http.Request, sessionToken string) error {
 	return nil
 }
 
+func isMultipart(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
 // CSRFMiddleware https://blog.jxck.io/entries/2024-04-26/csrf.html
 func Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -76,8 +89,8 @@ func Middleware(next http.Handler) http.Handler {
 			SameSite: http.SameSiteStrictMode,
 		})
 
-		// POST リクエストの場合はトークンを検証
-		if r.Method == http.MethodPost {
+		// 状態を変更するリクエストの場合はトークンを検証
+		if r.Method == http.MethodPost || r.Method == http.MethodPatch || r.Method == http.MethodDelete {
 			if err := validate(r, csrfToken); err != nil {
 				http.Error(w, "Forbidden: Invalid CSRF Token", http.StatusForbidden)
 				return
@@ -0,0 +1,192 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"go-form/core/charset"
+	"go-form/core/xlsx"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 1行ずつ書き出す。最後に必ず Close すること。書き出し先は閉じない
+type Encoder interface {
+	Write(row []any) error
+	Close() error
+}
+
+// format の Encoder を返す。見出し行がある形式はここで書き込む。encoding は CSV と TSV でだけ使う
+func NewEncoder(w io.Writer, format Format, encoding charset.Encoding, table Table) (Encoder, error) {
+	switch format {
+	case Csv, Tsv:
+		comma := table.Delimiter
+		if format == Tsv {
+			comma = '\t'
+		} else if comma == 0 {
+			comma = ','
+		}
+		out, err := charset.NewWriter(w, encoding)
+		if err != nil {
+			return nil, err
+		}
+		e := &csvEncoder{out: out, w: bufio.NewWriter(out), comma: comma}
+		if !table.NoHeader {
+			header := make([]any, len(table.Columns))
+			for i, c := range table.Columns {
+				header[i] = c
+			}
+			if err := e.Write(header); err != nil {
+				return nil, err
+			}
+		}
+		return e, nil
+	case Json, Ndjson:
+		keys := make([]string, len(table.Columns))
+		for i, c := range table.Columns {
+			key, err := json.Marshal(c)
+			if err != nil {
+				return nil, err
+			}
+			keys[i] = string(key)
+		}
+		return &jsonEncoder{w: bufio.NewWriter(w), keys: keys, array: format == Json}, nil
+	case Xlsx:
+		x, err := xlsx.NewWriter(w, table.Name, table.Columns)
+		if err != nil {
+			return nil, err
+		}
+		return xlsxEncoder{x}, nil
+	}
+	return nil, fmt.Errorf("export: no encoder for %q", format)
+}
+
+// 区切り文字で区切ったテキスト。encoding/csv の Writer と違い、# で始まる値も引用符で囲む
+// 取り込みの標準の設定では # で始まる行をコメントとして読み飛ばすため
+type csvEncoder struct {
+	out   io.WriteCloser // 文字コードの変換
+	w     *bufio.Writer
+	comma rune
+}
+
+func (e *csvEncoder) Write(row []any) error {
+	for i, v := range row {
+		if i > 0 {
+			e.w.WriteRune(e.comma)
+		}
+		e.w.WriteString(e.quote(text(v)))
+	}
+	_, err := e.w.WriteString("\n")
+	return err
+}
+
+func (e *csvEncoder) quote(s string) string {
+	if s == "" {
+		return s
+	}
+	if !strings.ContainsRune(s, e.comma) && !strings.ContainsAny(s, "\"\r\n") &&
+		!strings.HasPrefix(s, "#") && s[0] != ' ' && s[0] != '\t' {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func (e *csvEncoder) Close() error {
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	// 文字コードの変換で残っているバイト列を書き出す
+	return e.out.Close()
+}
+
+// CSV に書く値の文字列
+func text(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+// 列の順番を保つため、オブジェクトは map を使わずに組み立てる
+type jsonEncoder struct {
+	w     *bufio.Writer
+	keys  []string // JSON の文字列にした列名
+	array bool     // false の場合は NDJSON
+	rows  int
+}
+
+func (e *jsonEncoder) Write(row []any) error {
+	switch {
+	case !e.array:
+	case e.rows == 0:
+		e.w.WriteString("[\n")
+	default:
+		e.w.WriteString(",\n")
+	}
+	e.rows++
+
+	e.w.WriteByte('{')
+	for i, v := range row {
+		if i > 0 {
+			e.w.WriteByte(',')
+		}
+		if f, ok := v.(Fixed); ok {
+			v = json.Number(f.String())
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		e.w.WriteString(e.keys[i])
+		e.w.WriteByte(':')
+		e.w.Write(value)
+	}
+	e.w.WriteByte('}')
+	if !e.array {
+		e.w.WriteByte('\n')
+	}
+	return nil
+}
+
+func (e *jsonEncoder) Close() error {
+	if e.array {
+		if e.rows == 0 {
+			e.w.WriteString("[")
+		}
+		e.w.WriteString("\n]\n")
+	}
+	return e.w.Flush()
+}
+
+type xlsxEncoder struct {
+	w *xlsx.Writer
+}
+
+func (e xlsxEncoder) Write(row []any) error {
+	for i, v := range row {
+		if f, ok := v.(Fixed); ok {
+			// セルの値は丸めた値にする。表示の桁数は Excel の書式に任せる
+			row[i], _ = strconv.ParseFloat(f.String(), 64)
+		}
+	}
+	return e.w.Write(row)
+}
+
+func (e xlsxEncoder) Close() error {
+	return e.w.Close()
+}
@@ -0,0 +1,249 @@
+package export
+
+import (
+	"errors"
+	"go-form/core/charset"
+	"go-form/core/xlsx"
+	"iter"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// 書き出しの形式
+type Format string
+
+const (
+	Csv    Format = "csv"
+	Tsv    Format = "tsv"
+	Json   Format = "json"   // オブジェクトの配列
+	Ndjson Format = "ndjson" // 1行に1つのオブジェクト
+	Xlsx   Format = "xlsx"
+	Html   Format = "html" // 画面に表示する。ネゴシエーションの候補にだけ使い、エンコーダーはない
+)
+
+// ファイルとして書き出せる形式
+var Formats = []Format{Csv, Tsv, Json, Ndjson, Xlsx}
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported format")
+	ErrNotAcceptable     = errors.New("not acceptable")
+)
+
+func (f Format) Label() string {
+	switch f {
+	case Csv:
+		return "CSV"
+	case Tsv:
+		return "TSV"
+	case Json:
+		return "JSON"
+	case Ndjson:
+		return "NDJSON"
+	case Xlsx:
+		return "Excel"
+	case Html:
+		return "HTML"
+	}
+	return string(f)
+}
+
+// Content-Type の値。CSV と TSV の charset は書き出す文字コードに合わせて Write で付ける
+func (f Format) ContentType() string {
+	switch f {
+	case Csv:
+		return "text/csv"
+	case Tsv:
+		return "text/tab-separated-values"
+	case Json:
+		return "application/json"
+	case Ndjson:
+		return "application/x-ndjson"
+	case Xlsx:
+		return xlsx.ContentType
+	case Html:
+		return "text/html"
+	}
+	return "application/octet-stream"
+}
+
+// Accept ヘッダーで受け付けるメディアタイプ
+var mediaTypes = map[string]Format{
+	"text/csv":                  Csv,
+	"text/tab-separated-values": Tsv,
+	"application/json":          Json,
+	"application/x-ndjson":      Ndjson,
+	"application/jsonl":         Ndjson,
+	xlsx.ContentType:            Xlsx,
+	"text/html":                 Html,
+}
+
+// 書き出す形式を決める。クエリの format を優先し、なければ Accept ヘッダーから offered の中で最も優先度の高いものを選ぶ
+// どちらもない場合や */* の場合は offered の先頭を返す
+// format が offered にない場合は ErrUnsupportedFormat、Accept に一致するものがない場合は ErrNotAcceptable を返す
+func Negotiate(r *http.Request, offered ...Format) (Format, error) {
+	if v := r.URL.Query().Get("format"); v != "" {
+		if f := Format(strings.ToLower(v)); slices.Contains(offered, f) {
+			return f, nil
+		}
+		return "", ErrUnsupportedFormat
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return offered[0], nil
+	}
+	best, bestQ := Format(""), 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= bestQ {
+			continue
+		}
+		if f, ok := match(mediaType, offered); ok {
+			best, bestQ = f, q
+		}
+	}
+	if best == "" {
+		return "", ErrNotAcceptable
+	}
+	return best, nil
+}
+
+// mediaType に一致する offered の中で最初の形式。text/* のようなワイルドカードも扱う
+func match(mediaType string, offered []Format) (Format, bool) {
+	if mediaType == "*/*" {
+		return offered[0], true
+	}
+	if f, ok := mediaTypes[mediaType]; ok {
+		return f, slices.Contains(offered, f)
+	}
+	if prefix, ok := strings.CutSuffix(mediaType, "/*"); ok {
+		for _, f := range offered {
+			if strings.HasPrefix(f.ContentType(), prefix+"/") {
+				return f, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Negotiate のエラーをレスポンスに書き込む
+func WriteError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNotAcceptable) {
+		http.Error(w, "Not Acceptable", http.StatusNotAcceptable)
+		return
+	}
+	http.Error(w, "Unsupported format", http.StatusBadRequest)
+}
+
+// クエリの columns から書き出す列の名前を取り出す。columns=a,b と columns=a&columns=b のどちらでもよい
+func ColumnNames(q url.Values) []string {
+	var names []string
+	for _, v := range q["columns"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// 書き出す表。Rows は1行ずつ取り出すので、全体をメモリに読み込まなくてよい
+type Table struct {
+	Name      string   // 拡張子を除いたファイル名。xlsx のシート名にも使う
+	Columns   []string // 見出し。JSON ではオブジェクトのキーになる
+	Rows      iter.Seq2[[]any, error]
+	Delimiter rune // CSV の区切り文字。0 の場合はカンマ
+	NoHeader  bool // CSV と TSV で見出し行を書かない
+}
+
+// seq の要素を row で表の1行に変換する
+func Rows[T any](seq iter.Seq2[T, error], row func(T) []any) iter.Seq2[[]any, error] {
+	return func(yield func([]any, error) bool) {
+		for v, err := range seq {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(row(v), nil) {
+				return
+			}
+		}
+	}
+}
+
+// 小数点以下の桁数を固定して書き出す数値。xlsx と JSON では数値になる
+type Fixed struct {
+	Value  float64
+	Digits int
+}
+
+func (f Fixed) String() string {
+	return strconv.FormatFloat(f.Value, 'f', f.Digits, 64)
+}
+
+// table を format で書き出す。CSV と TSV の文字コードはクエリの encoding（既定は UTF-8）
+// 最初の行を書く前の失敗はエラーのレスポンスを返し、途中で失敗した場合はログに残して打ち切る
+func Write(w http.ResponseWriter, r *http.Request, format Format, table Table) {
+	encoding := charset.UTF8
+	if format == Csv || format == Tsv {
+		// Excel で開く場合は Shift_JIS か BOM 付きの UTF-8 を選ぶ
+		var err error
+		encoding, err = charset.Parse(r.URL.Query().Get("encoding"), charset.UTF8)
+		if err != nil || encoding == charset.Auto {
+			http.Error(w, "Unsupported encoding", http.StatusBadRequest)
+			return
+		}
+	}
+
+	next, stop := iter.Pull2(table.Rows)
+	defer stop()
+	row, err, ok := next()
+	if err != nil {
+		log.Printf("Data Fetch Error: %v", err)
+		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		return
+	}
+
+	contentType := format.ContentType()
+	if format == Csv || format == Tsv {
+		contentType += "; charset=" + encoding.Charset()
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": table.Name + "." + string(format)}))
+	w.Header().Add("Vary", "Accept")
+
+	encoder, err := NewEncoder(w, format, encoding, table)
+	if err != nil {
+		log.Printf("Export Encoder Error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	for ; ok; row, err, ok = next() {
+		if err != nil {
+			log.Printf("Data Fetch Error: %v", err)
+			return
+		}
+		if err := encoder.Write(row); err != nil {
+			log.Printf("Export Write Error: %v", err)
+			return
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		log.Printf("Export Close Error: %v", err)
+	}
+}
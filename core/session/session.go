@@ -16,9 +16,11 @@ import (
 const (
 	MaxLifetime = 60 * 60 * 24 // 1日
 	SId         = "s_id"
-	Dir         = "./tmp/sessions" // セッションデータ保存ディレクトリ
 )
 
+// セッションデータ保存ディレクトリ
+var Dir = "./tmp/sessions"
+
 type Manager struct {
 	mu sync.RWMutex
 }
@@ -158,3 +160,17 @@ func (session *Session) Save() error {
 func (session *Session) isExpired() bool {
 	return time.Now().After(session.ExpiresAt)
 }
+
+// ログイン中のユーザーIDを取得。未ログインの場合は空文字を返す
+func (session *Session) UserId() string {
+	// 保存前は構造体、ファイルから読み込んだ後は map になっているので JSON を経由して取り出す
+	data, err := json.Marshal(session.Values["user"])
+	if err != nil {
+		return ""
+	}
+	var user struct{ Id string }
+	if err := json.Unmarshal(data, &user); err != nil {
+		return ""
+	}
+	return user.Id
+}
@@ -0,0 +1,435 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotWorkbook   = errors.New("not an xlsx workbook")
+	ErrSheetNotFound = errors.New("sheet not found")
+	ErrPartTooLarge  = errors.New("xlsx part exceeds the size limit")
+)
+
+// 展開後の共有文字列 (xl/sharedStrings.xml) の大きさの上限。共有文字列はすべてメモリに読み込むので、
+// シートの上限とは別に小さく抑える。0 以下の場合は Open の limit と同じ
+var MaxSharedStringsBytes int64 = 256 << 20
+
+type Sheet struct {
+	Name string
+	path string // zip の中のパス
+}
+
+// xlsx ファイル。シートの行は Rows で1行ずつ読み出す
+// 共有文字列（セルの文字列）は行から番号で参照されるのでメモリに読み込む
+type Workbook struct {
+	Sheets []Sheet
+
+	zip        *zip.Reader
+	limit      int64
+	date1904   bool
+	strings    []string
+	dateStyles []bool // セルの書式番号ごとに日付かどうか
+}
+
+// r の xlsx を開く。limit は展開後の各パートの大きさの上限で、0 以下の場合は制限しない
+func Open(r io.ReaderAt, size int64, limit int64) (*Workbook, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotWorkbook, err)
+	}
+	wb := &Workbook{zip: zr, limit: limit}
+
+	var workbook struct {
+		Pr struct {
+			Date1904 string `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			Id   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := wb.decode("xl/workbook.xml", &workbook); err != nil {
+		if errors.Is(err, errPartNotFound) {
+			return nil, ErrNotWorkbook
+		}
+		return nil, err
+	}
+	wb.date1904 = workbook.Pr.Date1904 == "1" || workbook.Pr.Date1904 == "true"
+
+	var rels struct {
+		Relationships []struct {
+			Id     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := wb.decode("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	targets := map[string]string{}
+	for _, rel := range rels.Relationships {
+		// 相対パスは xl/ から、/ で始まる場合は zip の先頭から
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.Id] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.Id] = path.Join("xl", rel.Target)
+		}
+	}
+	for _, s := range workbook.Sheets {
+		if target, ok := targets[s.Id]; ok {
+			wb.Sheets = append(wb.Sheets, Sheet{Name: s.Name, path: target})
+		}
+	}
+	if len(wb.Sheets) == 0 {
+		return nil, fmt.Errorf("%w: no sheets", ErrNotWorkbook)
+	}
+
+	if err := wb.loadStyles(); err != nil {
+		return nil, err
+	}
+	if err := wb.loadStrings(); err != nil {
+		return nil, err
+	}
+	return wb, nil
+}
+
+// zip の中にファイルがない
+var errPartNotFound = errors.New("part not found")
+
+func (wb *Workbook) open(name string) (io.ReadCloser, error) {
+	return wb.openLimit(name, wb.limit)
+}
+
+// limit が 0 以下の場合は制限しない
+func (wb *Workbook) openLimit(name string, limit int64) (io.ReadCloser, error) {
+	for _, f := range wb.zip.File {
+		if f.Name != name {
+			continue
+		}
+		// ヘッダーのサイズは偽装できるが、archive/zip が展開時に超過を検出する
+		if limit > 0 && f.UncompressedSize64 > uint64(limit) {
+			return nil, fmt.Errorf("%s: %w", name, ErrPartTooLarge)
+		}
+		return f.Open()
+	}
+	return nil, fmt.Errorf("%s: %w", name, errPartNotFound)
+}
+
+func (wb *Workbook) decode(name string, v any) error {
+	rc, err := wb.open(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(rc).Decode(v); err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return nil
+}
+
+// 日付の書式のセルを見分けるために、セルの書式番号ごとの表示形式を読み込む
+func (wb *Workbook) loadStyles() error {
+	var styles struct {
+		NumFmts []struct {
+			Id   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		Xfs []struct {
+			NumFmtId int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	err := wb.decode("xl/styles.xml", &styles)
+	if errors.Is(err, errPartNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	codes := map[int]string{}
+	for _, f := range styles.NumFmts {
+		codes[f.Id] = f.Code
+	}
+	wb.dateStyles = make([]bool, len(styles.Xfs))
+	for i, xf := range styles.Xfs {
+		wb.dateStyles[i] = isDateFormat(xf.NumFmtId, codes[xf.NumFmtId])
+	}
+	return nil
+}
+
+// 組み込みの日付の表示形式か、独自の表示形式に日付や時刻の記号を含む
+func isDateFormat(id int, code string) bool {
+	switch {
+	case id >= 14 && id <= 22, id >= 27 && id <= 36, id >= 45 && id <= 47, id >= 50 && id <= 58:
+		return true
+	case code == "":
+		return false
+	}
+	// "…" の文字列と [Red] などの指定を除いてから記号を探す
+	var b strings.Builder
+	quoted, bracket := false, false
+	for _, c := range code {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '[':
+			bracket = true
+		case c == ']':
+			bracket = false
+		case !bracket:
+			b.WriteRune(c)
+		}
+	}
+	return strings.ContainsAny(strings.ToLower(b.String()), "ymdhs")
+}
+
+// セルの文字列。リッチテキストの場合は各部分をつなげる。ふりがな (rPh) は含めない
+type richText struct {
+	T string `xml:"t"`
+	R []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t richText) String() string {
+	if len(t.R) == 0 {
+		return t.T
+	}
+	var b strings.Builder
+	b.WriteString(t.T)
+	for _, r := range t.R {
+		b.WriteString(r.T)
+	}
+	return b.String()
+}
+
+// 上限を超える場合はシートを読み始める前にエラーにする
+func (wb *Workbook) loadStrings() error {
+	limit := wb.limit
+	if MaxSharedStringsBytes > 0 && (limit <= 0 || MaxSharedStringsBytes < limit) {
+		limit = MaxSharedStringsBytes
+	}
+	rc, err := wb.openLimit("xl/sharedStrings.xml", limit)
+	if errors.Is(err, errPartNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	dec := xml.NewDecoder(rc)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid xl/sharedStrings.xml: %w", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "si" {
+			var si richText
+			if err := dec.DecodeElement(&si, &start); err != nil {
+				return fmt.Errorf("invalid xl/sharedStrings.xml: %w", err)
+			}
+			wb.strings = append(wb.strings, si.String())
+		}
+	}
+}
+
+// 名前か1始まりの番号でシートを探す。空の場合は先頭のシート
+func (wb *Workbook) Sheet(name string) (Sheet, error) {
+	if name == "" {
+		return wb.Sheets[0], nil
+	}
+	for _, s := range wb.Sheets {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	if n, err := strconv.Atoi(name); err == nil && n >= 1 && n <= len(wb.Sheets) {
+		return wb.Sheets[n-1], nil
+	}
+	return Sheet{}, fmt.Errorf("%w: %q", ErrSheetNotFound, name)
+}
+
+// シートの行を先頭から読み出す。使い終わったら Close すること
+func (wb *Workbook) Rows(sheet Sheet) (*Rows, error) {
+	rc, err := wb.open(sheet.path)
+	if err != nil {
+		return nil, err
+	}
+	return &Rows{wb: wb, rc: rc, dec: xml.NewDecoder(rc)}, nil
+}
+
+// シートの行。空の行は読み飛ばす。多くは xlsx に含まれないが、書式だけのセルがある行や
+// セルのない <row/> は含まれることがあり、CSV の空行と同じく値のない行として扱う
+type Rows struct {
+	wb     *Workbook
+	rc     io.ReadCloser
+	dec    *xml.Decoder
+	row    int
+	record []string
+}
+
+// 次の行のセルの値を列の順に返す。途中の空のセルは空文字列になる
+// 返したスライスは次の呼び出しで再利用する。最後まで読んだ場合は io.EOF を返す
+func (r *Rows) Next() ([]string, error) {
+	var cell struct {
+		col   int
+		typ   string
+		style int
+		value string
+	}
+	for {
+		tok, err := r.dec.Token()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid sheet: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				r.record = r.record[:0]
+				r.row++
+				if n, err := strconv.Atoi(attr(t, "r")); err == nil {
+					r.row = n
+				}
+			case "c":
+				cell.col = len(r.record)
+				if col, ok := columnIndex(attr(t, "r")); ok {
+					cell.col = col
+				}
+				cell.typ = attr(t, "t")
+				cell.style, _ = strconv.Atoi(attr(t, "s"))
+				cell.value = ""
+			case "v":
+				if err := r.dec.DecodeElement(&cell.value, &t); err != nil {
+					return nil, fmt.Errorf("invalid sheet: %w", err)
+				}
+			case "is":
+				var text richText
+				if err := r.dec.DecodeElement(&text, &t); err != nil {
+					return nil, fmt.Errorf("invalid sheet: %w", err)
+				}
+				cell.value = text.String()
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "c":
+				for len(r.record) < cell.col {
+					r.record = append(r.record, "")
+				}
+				r.record = append(r.record[:cell.col], r.wb.cellText(cell.typ, cell.style, cell.value))
+			case "row":
+				if slices.ContainsFunc(r.record, func(v string) bool { return v != "" }) {
+					return r.record, nil
+				}
+			case "sheetData":
+				return nil, io.EOF
+			}
+		}
+	}
+}
+
+// 直前に読んだ行の行番号（1始まり）
+func (r *Rows) Row() int {
+	return r.row
+}
+
+func (r *Rows) Close() error {
+	return r.rc.Close()
+}
+
+// セルの種類に応じて表示される文字列にする。日付の書式の数値は 2006-01-02 15:04:05 の形式にする
+func (wb *Workbook) cellText(typ string, style int, value string) string {
+	switch typ {
+	case "s":
+		if i, err := strconv.Atoi(value); err == nil && i >= 0 && i < len(wb.strings) {
+			return wb.strings[i]
+		}
+		return ""
+	case "b":
+		if value == "1" {
+			return "TRUE"
+		}
+		return "FALSE"
+	case "", "n":
+		if style >= 0 && style < len(wb.dateStyles) && wb.dateStyles[style] {
+			if serial, err := strconv.ParseFloat(value, 64); err == nil {
+				return formatSerial(serial, wb.date1904)
+			}
+		}
+	}
+	return value
+}
+
+// Excel の日付のシリアル値。1900 年の閏年のバグのため 1899-12-30 を起点にする
+var (
+	epoch1900 = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	epoch1904 = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func formatSerial(serial float64, date1904 bool) string {
+	epoch := epoch1900
+	if date1904 {
+		epoch = epoch1904
+	}
+	t := epoch.Add(time.Duration(serial * float64(24*time.Hour))).Round(time.Second)
+	if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 {
+		return t.Format(time.DateOnly)
+	}
+	return t.Format(time.DateTime)
+}
+
+// 日付をシリアル値にする。タイムゾーンは無視して表示される日時をそのまま使う
+func serialOf(t time.Time) float64 {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	return float64(wall.Sub(epoch1900)) / float64(24*time.Hour)
+}
+
+func attr(e xml.StartElement, name string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// "BC12" のようなセル番地の列を0始まりの番号にする
+func columnIndex(ref string) (int, bool) {
+	col := 0
+	n := 0
+	for _, c := range ref {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		col = col*26 + int(c-'A'+1)
+		n++
+	}
+	if n == 0 || n > 3 {
+		return 0, false
+	}
+	return col - 1, true
+}
+
+// 0始まりの列番号を "A", "B", …, "AA" の形式にする
+func columnName(col int) string {
+	name := ""
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = string(rune('A'+(col-1)%26)) + name
+	}
+	return name
+}
@@ -0,0 +1,132 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// sheetData と共有文字列の si を持つ1シートの xlsx
+func testWorkbook(t *testing.T, sheetData, sharedStrings string) []byte {
+	t.Helper()
+	parts := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="data" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships><Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/worksheets/sheet1.xml":   "<worksheet><sheetData>" + sheetData + "</sheetData></worksheet>",
+		"xl/sharedStrings.xml":       "<sst>" + sharedStrings + "</sst>",
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range parts {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// 共有文字列を count 個持つ1シートの xlsx
+func sharedStringsWorkbook(t *testing.T, count int) []byte {
+	t.Helper()
+	var si strings.Builder
+	for i := range count {
+		fmt.Fprintf(&si, "<si><t>city%d</t></si>", i)
+	}
+	return testWorkbook(t, `<row r="1"><c r="A1" t="s"><v>1</v></c></row>`, si.String())
+}
+
+func TestOpenLimitsSharedStrings(t *testing.T) {
+	data := sharedStringsWorkbook(t, 1000) // 共有文字列は 20KB 程度
+
+	tests := []struct {
+		name          string
+		limit         int64
+		sharedStrings int64
+		wantErr       error
+	}{
+		{"within both limits", 1 << 20, 1 << 20, nil},
+		{"over the shared strings limit", 1 << 20, 1 << 10, ErrPartTooLarge},
+		{"over the part limit", 1 << 10, 1 << 20, ErrPartTooLarge},
+		{"shared strings limit disabled", 1 << 20, 0, nil},
+		{"both disabled", 0, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(v int64) { MaxSharedStringsBytes = v }(MaxSharedStringsBytes)
+			MaxSharedStringsBytes = tt.sharedStrings
+
+			wb, err := Open(bytes.NewReader(data), int64(len(data)), tt.limit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			rows, err := wb.Rows(wb.Sheets[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			record, err := rows.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(record) != 1 || record[0] != "city1" {
+				t.Errorf("record = %q, want [city1]", record)
+			}
+		})
+	}
+}
+
+func TestRowsSkipsEmptyRows(t *testing.T) {
+	sheetData := `<row r="1"><c r="A1" t="inlineStr"><is><t>city</t></is></c><c r="B1" t="inlineStr"><is><t>temperature</t></is></c></row>` +
+		`<row r="2"/>` +
+		`<row r="3"><c r="A3" t="inlineStr"><is><t>Tokyo</t></is></c><c r="B3"><v>10.5</v></c></row>` +
+		`<row r="4" ht="20" customHeight="1"></row>` +
+		`<row r="5"><c r="A5" s="1"/><c r="B5" s="1"/></row>` + // 書式だけのセル
+		`<row r="6"><c r="B6"><v>-3</v></c></row>` +
+		`<row r="7"/>`
+	data := testWorkbook(t, sheetData, "")
+	wb, err := Open(bytes.NewReader(data), int64(len(data)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := wb.Rows(wb.Sheets[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	want := []struct {
+		row    int
+		record string
+	}{
+		{1, "city|temperature"},
+		{3, "Tokyo|10.5"},
+		{6, "|-3"},
+	}
+	for _, w := range want {
+		record, err := rows.Next()
+		if err != nil {
+			t.Fatalf("row %d: %v", w.row, err)
+		}
+		if got := strings.Join(record, "|"); got != w.record || rows.Row() != w.row {
+			t.Errorf("row %d = %q, want row %d = %q", rows.Row(), got, w.row, w.record)
+		}
+	}
+	if record, err := rows.Next(); err != io.EOF {
+		t.Errorf("after the last row: %q, %v, want io.EOF", record, err)
+	}
+}
@@ -3,6 +3,7 @@ module go-form
 go 1.23
 
 require (
-	github.com/lib/pq v1.10.9 // indirect
-	golang.org/x/crypto v0.29.0 // indirect
+	github.com/lib/pq v1.10.9
+	golang.org/x/crypto v0.29.0
+	golang.org/x/text v0.20.0
 )
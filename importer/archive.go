@@ -0,0 +1,207 @@
+package importer
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// 圧縮ファイルの展開後のサイズの上限。zip 爆弾で取り込みが終わらなくなるのを防ぐ
+var (
+	MaxUncompressedBytes int64 = 16 << 30 // 1ファイルあたり
+	MaxArchiveBytes      int64 = 64 << 30 // zip の全ファイルの合計
+	MaxArchiveMembers          = 100
+	MaxCompressionRatio  int64 = 200 // CSV は通常 10 倍程度までしか縮まない
+)
+
+var (
+	ErrTooLarge     = errors.New("uncompressed size exceeds the limit")
+	ErrRatio        = errors.New("compression ratio exceeds the limit")
+	ErrEmptyArchive = errors.New("zip archive contains no CSV files")
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+)
+
+// zip の中の取り込み対象のファイル名。zip でない場合は空文字1つを返す
+func Members(name string) ([]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	head := make([]byte, len(zipMagic))
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if !bytes.Equal(head[:n], zipMagic) {
+		return []string{""}, nil
+	}
+
+	archive, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip archive: %w", err)
+	}
+	defer archive.Close()
+
+	// xlsx も zip だが、1つのファイルとして ImportXlsx で読む
+	for _, f := range archive.File {
+		if f.Name == "xl/workbook.xml" {
+			return []string{""}, nil
+		}
+	}
+
+	var members []string
+	var total uint64
+	for _, f := range archive.File {
+		if !isCsvMember(f.Name) || f.FileInfo().IsDir() {
+			continue
+		}
+		if len(members) >= MaxArchiveMembers {
+			return nil, fmt.Errorf("zip archive contains more than %d CSV files", MaxArchiveMembers)
+		}
+		// ヘッダーのサイズは偽装できるが、archive/zip が展開時に超過を検出するので上限の判定に使える
+		if f.UncompressedSize64 > uint64(MaxUncompressedBytes) {
+			return nil, fmt.Errorf("%s: %w", f.Name, ErrTooLarge)
+		}
+		if f.UncompressedSize64 > max(f.CompressedSize64, 1)*uint64(MaxCompressionRatio) {
+			return nil, fmt.Errorf("%s: %w", f.Name, ErrRatio)
+		}
+		total += f.UncompressedSize64
+		if total > uint64(MaxArchiveBytes) {
+			return nil, fmt.Errorf("zip archive: %w", ErrTooLarge)
+		}
+		members = append(members, f.Name)
+	}
+	if len(members) == 0 {
+		return nil, ErrEmptyArchive
+	}
+	return members, nil
+}
+
+// 取り込み対象にする zip の中のファイル。macOS が作るメタデータや隠しファイルは除く
+func isCsvMember(name string) bool {
+	if strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), ".") {
+		return false
+	}
+	switch path.Ext(strings.TrimSuffix(strings.ToLower(name), ".gz")) {
+	case ".csv", ".tsv", ".txt":
+		return true
+	}
+	return false
+}
+
+// 保存したアップロードを開く。member が空でない場合は zip の中のそのファイルを開く
+// gzip で圧縮されている場合は展開しながら読む。xlsx の場合は ImportXlsx が読めるようファイルをそのまま返す
+func openUpload(name, member string) (io.ReadCloser, error) {
+	if member == "" {
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		head := make([]byte, len(zipMagic))
+		if n, _ := file.ReadAt(head, 0); bytes.Equal(head[:n], zipMagic) {
+			return file, nil
+		}
+		return decompress(file)
+	}
+
+	archive, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip archive: %w", err)
+	}
+	for _, f := range archive.File {
+		if f.Name != member {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			archive.Close()
+			return nil, err
+		}
+		r, err := decompress(multiCloser{rc, []io.Closer{rc, archive}})
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	archive.Close()
+	return nil, fmt.Errorf("%s not found in zip archive", member)
+}
+
+// 先頭が gzip のマジックナンバーなら展開する Reader を返す。それ以外はそのまま返す
+func decompress(rc io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(rc)
+	head, err := br.Peek(len(gzipMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		rc.Close()
+		return nil, err
+	}
+	if !bytes.Equal(head, gzipMagic) {
+		return multiCloser{br, []io.Closer{rc}}, nil
+	}
+
+	counter := &countingReader{r: br}
+	gz, err := gzip.NewReader(counter)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("invalid gzip file: %w", err)
+	}
+	return multiCloser{&guardReader{r: gz, compressed: counter}, []io.Closer{gz, rc}}, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// gzip はヘッダーに展開後のサイズを持たないので、読みながら上限と圧縮率を確認する
+type guardReader struct {
+	r          io.Reader
+	compressed *countingReader
+	n          int64
+}
+
+// 圧縮率は先頭の短いデータでは大きく出るので、この大きさを超えてから確認する
+const ratioSlack = 1 << 20
+
+func (g *guardReader) Read(p []byte) (int, error) {
+	n, err := g.r.Read(p)
+	g.n += int64(n)
+	if g.n > MaxUncompressedBytes {
+		return n, ErrTooLarge
+	}
+	if g.n > ratioSlack && g.n > g.compressed.n*MaxCompressionRatio {
+		return n, ErrRatio
+	}
+	return n, err
+}
+
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m multiCloser) Close() error {
+	var errs []error
+	for _, c := range m.closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
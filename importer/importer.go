@@ -0,0 +1,306 @@
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"go-form/core/charset"
+	"go-form/repo"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	DefaultBatchSize = 1000
+	DefaultMaxErrors = 100
+)
+
+// 重複除外・上書きモードで観測日時の列がない。各行をファイルの自然キー (city, measured_at) で突き合わせるため
+var ErrMeasuredAtRequired = errors.New("measured_at column is required in skip and upsert modes")
+
+// アップロードの形式
+type Format string
+
+const (
+	FormatCsv  Format = "csv"  // Options.Profile の形式の CSV
+	FormatJson Format = "json" // JSON 配列か NDJSON
+	FormatXlsx Format = "xlsx" // Options.Sheet のシートを Options.Profile に従って読む
+)
+
+// 取り込めなかった行
+type RowError = repo.ImportError
+
+// 検証を通過した行の保存先。repo.WeatherStationStore を渡す。プレビューでは保存せずに捨てる
+type Loader interface {
+	Import(mode repo.ImportMode, values []repo.WeatherStation) (repo.ImportResult, error)
+}
+
+type Options struct {
+	Mode       repo.ImportMode
+	Format     Format             // 空の場合は FormatCsv
+	Profile    repo.ImportProfile // ファイルの形式。ゼロ値の場合は repo.DefaultImportProfile
+	Encoding   charset.Encoding   // ファイルの文字コード。空の場合は自動判定する
+	Sheet      string             // xlsx のシート名か1始まりの番号。空の場合は先頭のシート
+	ImportId   string
+	UploadedBy string
+	UploadedAt time.Time // 観測日時がない行に使う。重複除外・上書きモードでは使わず、観測日時がない行は不正な行にする
+	Unit       Unit      // ファイルの気温の単位。空の場合は摂氏
+	Checks     Checks    // 摂氏に変換した気温の妥当性の確認。ゼロ値の場合は確認しない
+	MaxErrors  int       // 不正な行がこの件数を超えたら中断する。負の場合は最後まで続ける
+	BatchSize  int
+	Workers    int           // 並列に検証する goroutine の数。0 の場合は DefaultWorkers
+	OnBatch    func(*Report) `json:"-"` // バッチを保存するたびに呼ばれる。進捗の記録に使う
+}
+
+// 取り込み結果
+type Report struct {
+	ImportId string
+	Mode     repo.ImportMode
+	Encoding charset.Encoding // 読み込んだ文字コード（自動判定の場合は判定結果）
+	Sheet    string           // 読み込んだ xlsx のシート名
+	Header   []string         // 見出し行。ない場合は nil
+	Fields   int              // 最初のデータ行の列数
+	Result   repo.ImportResult
+	Accepted int64      // 検証を通過した行数
+	Rejects  int64      // 不正な行数
+	Rejected []RowError // 不正な行。OnBatch で取り出して空にしてもよい
+	Aborted  bool       // 不正な行が上限を超えて中断した
+}
+
+// 読み込んだ行数（不正な行を含む）
+func (r *Report) Processed() int64 {
+	return r.Accepted + r.Rejects
+}
+
+// opts.Format の形式で読み込んで保存する
+func Import(ctx context.Context, r io.Reader, stations Loader, opts Options) (*Report, error) {
+	switch opts.Format {
+	case FormatJson:
+		return ImportJson(ctx, r, stations, opts)
+	case FormatXlsx:
+		return ImportXlsx(ctx, r, stations, opts)
+	}
+	return ImportCsv(ctx, r, stations, opts)
+}
+
+// opts.Profile の形式（既定はセミコロン区切りの city;temperature[;measured_at]）で読み込んで保存する
+// 不正な行は Report.Rejected に記録して続行し、保存や読み込み自体に失敗した場合のみ error を返す
+// ctx がキャンセルされた場合は ctx.Err() を返す。それまでに保存したバッチは残る
+func ImportCsv(ctx context.Context, r io.Reader, stations Loader, opts Options) (*Report, error) {
+	opts = opts.withDefaults()
+	if opts.Encoding == "" {
+		opts.Encoding = charset.Auto
+	}
+
+	report := &Report{ImportId: opts.ImportId, Mode: opts.Mode}
+	// UTF-8 に変換してから読み込む
+	r, encoding, err := charset.NewReader(r, opts.Encoding)
+	if err != nil {
+		return report, fmt.Errorf("failed to read CSV: %w", err)
+	}
+	report.Encoding = encoding
+	return report, importTable(ctx, newRecordReader(r, opts.Profile), stations, opts, report)
+}
+
+// 先頭の行と見出し行を opts.Profile に従って読み飛ばしてから、残りの行を保存する
+func importTable(ctx context.Context, reader recordReader, stations Loader, opts Options, report *Report) error {
+	// 先頭の不要な行と見出し行を読み飛ばす
+	var header []string
+	for i := 0; i < opts.Profile.SkipRows || (opts.Profile.Header && header == nil); i++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) && i < opts.Profile.SkipRows {
+			continue // 読み飛ばす行は形式が崩れていてもよい
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read upload: %w", err)
+		}
+		if i >= opts.Profile.SkipRows {
+			header = slices.Clone(record)
+		}
+	}
+	report.Header = header
+	cols, err := resolveColumns(opts.Profile, header)
+	if err != nil {
+		return err
+	}
+	return importRecords(ctx, reader, cols, stations, opts, report)
+}
+
+// JSON 配列か NDJSON（1行に1つ）の {"city", "temperature", "measured_at"} を読み込んで保存する
+// 検証と保存は ImportCsv と同じで、RowError.Line は先頭から何番目のオブジェクトか
+// JSON として壊れている場合はその位置から先を読めないので error を返す
+func ImportJson(ctx context.Context, r io.Reader, stations Loader, opts Options) (*Report, error) {
+	opts = opts.withDefaults()
+	opts.Profile = repo.DefaultImportProfile() // parse が小数点の設定を参照する
+	report := &Report{ImportId: opts.ImportId, Mode: opts.Mode, Encoding: charset.UTF8}
+	return report, importRecords(ctx, newJsonReader(r), jsonColumns, stations, opts, report)
+}
+
+func (opts Options) withDefaults() Options {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.UploadedAt.IsZero() {
+		opts.UploadedAt = time.Now()
+	}
+	if opts.Profile.Delimiter == "" {
+		opts.Profile = repo.DefaultImportProfile()
+	}
+	return opts
+}
+
+// reader の残りの行を検証し、opts.BatchSize 行ずつ stations に保存して report に集計する
+func importRecords(ctx context.Context, reader recordReader, cols columns, stations Loader, opts Options, report *Report) error {
+	if opts.Mode.Keyed() && cols.measuredAt < 0 {
+		return ErrMeasuredAtRequired
+	}
+
+	reject := func(e RowError) {
+		report.Rejected = append(report.Rejected, e)
+		report.Rejects++
+		if opts.MaxErrors >= 0 && report.Rejects > int64(opts.MaxErrors) {
+			report.Aborted = true
+		}
+	}
+
+	var weatherStations []repo.WeatherStation
+	flush := func() error {
+		batch, err := stations.Import(opts.Mode, weatherStations)
+		if err != nil {
+			return err
+		}
+		report.Result.Add(batch)
+		weatherStations = weatherStations[:0]
+		if opts.OnBatch != nil {
+			opts.OnBatch(report)
+		}
+		return nil
+	}
+
+	// 読み込みと検証は並列に行い、元の行順に受け取って保存する
+	for o, err := range parseRows(ctx, reader, cols, opts) {
+		if err != nil {
+			return err
+		}
+		if report.Fields == 0 {
+			report.Fields = o.fields
+			// 最初の行に観測日時の列がなければ、ファイルに列がないとみなしてすべての行を不正にせずに中断する
+			if opts.Mode.Keyed() && o.fields > 0 && o.fields <= cols.measuredAt {
+				return ErrMeasuredAtRequired
+			}
+		}
+		if !o.ok {
+			reject(o.reject)
+			if report.Aborted {
+				break
+			}
+			continue
+		}
+		weatherStations = append(weatherStations, o.station)
+		report.Accepted++
+
+		if len(weatherStations) >= opts.BatchSize {
+			// データベースに挿入
+			if err := flush(); err != nil {
+				return fmt.Errorf("failed to insert record: %w", err)
+			}
+		}
+	}
+
+	if len(weatherStations) > 0 {
+		// データベースに挿入
+		if err := flush(); err != nil {
+			return fmt.Errorf("failed to insert record: %w", err)
+		}
+	} else if opts.OnBatch != nil {
+		opts.OnBatch(report)
+	}
+	return nil
+}
+
+// 1行を検証して観測値に変換する
+func parse(record []string, cols columns, opts Options) (repo.WeatherStation, error) {
+	if len(record) < cols.required() {
+		return repo.WeatherStation{}, fmt.Errorf("expected at least %d fields, got %d", cols.required(), len(record))
+	}
+
+	city := strings.TrimSpace(record[cols.city])
+	if city == "" {
+		return repo.WeatherStation{}, errors.New("city is empty")
+	}
+	if !utf8.ValidString(city) {
+		return repo.WeatherStation{}, errors.New("city is not valid UTF-8")
+	}
+	if utf8.RuneCountInString(city) > 255 {
+		return repo.WeatherStation{}, errors.New("city is longer than 255 characters")
+	}
+
+	temperature := strings.TrimSpace(record[cols.temperature])
+	if opts.Profile.DecimalSeparator == "," {
+		// 12,5 のような小数点がカンマの書式。1.234,5 のような桁区切りは受け付けない
+		if strings.Contains(temperature, ".") {
+			return repo.WeatherStation{}, fmt.Errorf("invalid temperature %q", record[cols.temperature])
+		}
+		temperature = strings.Replace(temperature, ",", ".", 1)
+	}
+	v, err := strconv.ParseFloat(temperature, 32)
+	if err != nil {
+		return repo.WeatherStation{}, fmt.Errorf("invalid temperature %q", record[cols.temperature])
+	}
+	v = roundCelsius(opts.Unit.toCelsius(v))
+	if err := opts.Checks.check(temperature, v); err != nil {
+		return repo.WeatherStation{}, err
+	}
+	// NUMERIC(10, 4) に収まる範囲
+	if math.IsNaN(v) || math.Abs(v) >= 1e6 {
+		return repo.WeatherStation{}, fmt.Errorf("temperature %q is out of range", record[cols.temperature])
+	}
+
+	measuredAt := opts.UploadedAt
+	if cols.measuredAt >= 0 && cols.measuredAt < len(record) && strings.TrimSpace(record[cols.measuredAt]) != "" {
+		measuredAt, err = parseMeasuredAt(strings.TrimSpace(record[cols.measuredAt]))
+		if err != nil {
+			return repo.WeatherStation{}, err
+		}
+	} else if opts.Mode.Keyed() {
+		// アップロード日時で補うと同じ都市の行が同じ自然キーになってしまう
+		return repo.WeatherStation{}, errors.New("measured_at is required in skip and upsert modes")
+	}
+
+	return repo.WeatherStation{
+		City:        city,
+		Temperature: float32(v),
+		MeasuredAt:  measuredAt,
+		UploadedBy:  opts.UploadedBy,
+		ImportId:    opts.ImportId,
+	}, nil
+}
+
+// 観測日時として受け付ける書式
+var measuredAtLayouts = []string{
+	time.RFC3339Nano,
+	time.DateTime,
+	"2006-01-02T15:04:05",
+	"2006/01/02 15:04:05",
+	time.DateOnly,
+	"2006/01/02",
+}
+
+func parseMeasuredAt(value string) (time.Time, error) {
+	for _, layout := range measuredAtLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid measured_at %q", value)
+}
@@ -0,0 +1,155 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// 1行（JSON の場合は1オブジェクト）だけ読めなかった。次の行から読み続けられる
+type recordError struct {
+	line int
+	err  error
+}
+
+func (e *recordError) Error() string {
+	return fmt.Sprintf("record %d: %v", e.line, e.err)
+}
+
+func (e *recordError) Unwrap() error {
+	return e.err
+}
+
+// jsonReader が返す列の並び
+var jsonColumns = columns{city: 0, temperature: 1, measuredAt: 2}
+
+// JSON のオブジェクトを CSV と同じ列の並び（city, temperature, measured_at）にして1件ずつ返す
+// 先頭が [ の場合は JSON 配列、それ以外は空白区切りで並んだオブジェクト（NDJSON）として読む
+type jsonReader struct {
+	r       *bufio.Reader
+	dec     *json.Decoder
+	array   bool
+	n       int    // 読んだオブジェクトの数
+	raw     string // 直前に読んだオブジェクト
+	record  []string
+	started bool
+}
+
+type jsonMeasurement struct {
+	City        json.RawMessage `json:"city"`
+	Temperature json.RawMessage `json:"temperature"`
+	MeasuredAt  json.RawMessage `json:"measured_at"`
+}
+
+func newJsonReader(r io.Reader) *jsonReader {
+	br := bufio.NewReader(r)
+	return &jsonReader{r: br, dec: json.NewDecoder(br), record: make([]string, 3)}
+}
+
+func (j *jsonReader) Read() ([]string, error) {
+	if !j.started {
+		j.started = true
+		array, err := j.startsWithArray()
+		if err != nil {
+			return nil, err
+		}
+		if array {
+			j.array = true
+			if _, err := j.dec.Token(); err != nil {
+				return nil, fmt.Errorf("invalid JSON: %w", err)
+			}
+		}
+	}
+	if j.array && !j.dec.More() {
+		// 閉じ括弧まで読めれば終わり
+		if _, err := j.dec.Token(); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return nil, io.EOF
+	}
+
+	var raw json.RawMessage
+	if err := j.dec.Decode(&raw); err != nil {
+		if err == io.EOF && !j.array {
+			return nil, io.EOF
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("invalid JSON after record %d: %w", j.n, err)
+	}
+	j.n++
+	j.raw = string(raw)
+
+	var m jsonMeasurement
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, &recordError{line: j.n, err: errors.New("expected an object")}
+	}
+	city, err := jsonString(m.City, false)
+	if err != nil {
+		return nil, &recordError{line: j.n, err: fmt.Errorf("city %w", err)}
+	}
+	// 気温は数値のほか "12.5" のような文字列も受け付ける
+	temperature, err := jsonString(m.Temperature, true)
+	if err != nil {
+		return nil, &recordError{line: j.n, err: fmt.Errorf("temperature %w", err)}
+	}
+	measuredAt, err := jsonString(m.MeasuredAt, false)
+	if err != nil {
+		return nil, &recordError{line: j.n, err: fmt.Errorf("measured_at %w", err)}
+	}
+	j.record[0], j.record[1], j.record[2] = city, temperature, measuredAt
+	return j.record, nil
+}
+
+func (j *jsonReader) Line() int {
+	return j.n
+}
+
+func (j *jsonReader) Raw() string {
+	return j.raw
+}
+
+// 空白を読み飛ばして、先頭が [ かどうかを調べる
+func (j *jsonReader) startsWithArray() (bool, error) {
+	for {
+		b, err := j.r.Peek(1)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			j.r.Discard(1)
+		default:
+			return b[0] == '[', nil
+		}
+	}
+}
+
+// 文字列の値を返す。null や省略された場合は空文字列。number が true の場合は数値も文字列として受け付ける
+func jsonString(raw json.RawMessage, number bool) (string, error) {
+	text := strings.TrimSpace(string(raw))
+	if text == "" || text == "null" {
+		return "", nil
+	}
+	if text[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return "", err
+		}
+		return s, nil
+	}
+	if number && (text[0] == '-' || text[0] >= '0' && text[0] <= '9') {
+		return text, nil
+	}
+	if number {
+		return "", errors.New("must be a number or a string")
+	}
+	return "", errors.New("must be a string")
+}
@@ -0,0 +1,241 @@
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"go-form/repo"
+	"io"
+	"iter"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// 並列に検証する goroutine の数の既定値。起動時に IMPORT_WORKERS で変更できる
+var DefaultWorkers = runtime.GOMAXPROCS(0)
+
+const chunkRows = 1024 // 検証する goroutine に1回で渡す行数
+
+// 読み込んだ1行。引用符の不正などで読めなかった行は err に理由が入る
+type rawRow struct {
+	line   int
+	record []string
+	raw    string // 不正な場合に記録する元の行。空の場合は record を区切り文字でつなげる
+	err    error
+}
+
+// 元の行をそのまま返せる recordReader
+type rawReader interface {
+	Raw() string
+}
+
+// 区切りを探すだけで、元のテキストのまま chunk に切り出せる recordReader
+// 列への分割は検証する goroutine で並列に行う
+type textChunker interface {
+	readChunk() (c chunk, eof bool)
+	split(rows []rawRow, text []byte, line int) []rawRow // 並列に呼ばれる
+}
+
+// 連続した行のまとまり。seq は先頭からの通し番号
+// textChunker から読んだ場合は、rows の後に text を分割した行が続く
+type chunk struct {
+	seq  int
+	rows []rawRow
+	text []byte // まだ分割していない元のテキスト
+	line int    // text の先頭の行番号
+	err  error  // 読み込み自体の失敗。この chunk が最後になる
+}
+
+// 1行の検証結果。ok が false の場合は reject に理由が入る
+type outcome struct {
+	station repo.WeatherStation
+	reject  RowError
+	ok      bool
+	fields  int // 読み込めた列の数。読めなかった行は 0
+}
+
+type parsed struct {
+	seq      int
+	outcomes []outcome
+	err      error
+}
+
+// reader の残りの行を検証して、元の行順に返す
+// 読み込みは1つの goroutine、検証は opts.Workers 個の goroutine で並列に行う
+// CSV は読み込む goroutine では行の区切りだけを探し、列への分割も検証する goroutine で行う
+// 受け取り待ちの chunk は opts.Workers の2倍までなので、呼び出し側の保存が遅い場合は読み込みも止まる
+// ループを途中で抜けると goroutine を止めてから戻る
+func parseRows(ctx context.Context, reader recordReader, cols columns, opts Options) iter.Seq2[outcome, error] {
+	return func(yield func(outcome, error) bool) {
+		workers := opts.Workers
+		if workers <= 0 {
+			workers = max(DefaultWorkers, 1)
+		}
+		parent := ctx
+		ctx, cancel := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		defer cancel()
+
+		chunks := make(chan chunk, workers)
+		results := make(chan parsed, workers)
+		tokens := make(chan struct{}, workers*2) // 受け取り待ちの chunk の数を制限する
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(chunks)
+			readChunks(ctx, reader, chunks, tokens)
+		}()
+
+		splitter, _ := reader.(textChunker)
+		var parsers sync.WaitGroup
+		for range workers {
+			parsers.Add(1)
+			go func() {
+				defer parsers.Done()
+				for c := range chunks {
+					rows := c.rows
+					if len(c.text) > 0 {
+						rows = splitter.split(rows, c.text, c.line)
+					}
+					p := parsed{seq: c.seq, outcomes: make([]outcome, len(rows)), err: c.err}
+					for i, row := range rows {
+						p.outcomes[i] = parseRow(row, cols, opts)
+					}
+					select {
+					case results <- p:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			parsers.Wait()
+			close(results)
+		}()
+
+		// 順番が前後して届いた chunk は、前の chunk を返し終わるまで取っておく
+		pending := map[int]parsed{}
+		for next := 0; ; next++ {
+			p, ok := pending[next]
+			delete(pending, next)
+			for !ok {
+				if err := parent.Err(); err != nil {
+					yield(outcome{}, err)
+					return
+				}
+				select {
+				case r, open := <-results:
+					if !open {
+						return
+					}
+					if r.seq == next {
+						p, ok = r, true
+					} else {
+						pending[r.seq] = r
+					}
+				case <-parent.Done():
+				}
+			}
+
+			for _, o := range p.outcomes {
+				if !yield(o, nil) {
+					return
+				}
+			}
+			if p.err != nil {
+				yield(outcome{}, p.err)
+				return
+			}
+			<-tokens
+		}
+	}
+}
+
+// reader を chunkRows 行ずつに分けて chunks に送る
+func readChunks(ctx context.Context, reader recordReader, chunks chan<- chunk, tokens chan<- struct{}) {
+	read := func() (chunk, bool) { return readRecords(reader) }
+	if t, ok := reader.(textChunker); ok {
+		read = t.readChunk
+	}
+	for seq := 0; ; seq++ {
+		c, eof := read()
+		c.seq = seq
+		if len(c.rows) == 0 && len(c.text) == 0 && c.err == nil {
+			return
+		}
+
+		select {
+		case tokens <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case chunks <- c:
+		case <-ctx.Done():
+			return
+		}
+		if eof || c.err != nil {
+			return
+		}
+	}
+}
+
+// reader から chunkRows 行を読んで分割済みの chunk にする。JSON と xlsx は1件ずつしか読めないのでこちらを使う
+func readRecords(reader recordReader) (c chunk, eof bool) {
+	raws, _ := reader.(rawReader)
+	c.rows = make([]rawRow, 0, chunkRows)
+	for !eof && c.err == nil && len(c.rows) < chunkRows {
+		record, err := reader.Read()
+		var parseErr *csv.ParseError
+		var recordErr *recordError
+		switch {
+		case err == io.EOF:
+			eof = true // ファイルの終わりに到達
+		case errors.As(err, &parseErr):
+			// 引用符の不正などは行ごと読めないので、その行を記録して次の行へ進む
+			c.rows = append(c.rows, rawRow{line: parseErr.StartLine, err: parseErr.Err})
+		case errors.As(err, &recordErr):
+			row := rawRow{line: recordErr.line, err: recordErr.err}
+			if raws != nil {
+				row.raw = raws.Raw()
+			}
+			c.rows = append(c.rows, row)
+		case err != nil:
+			c.err = fmt.Errorf("failed to read upload: %w", err)
+		default:
+			// xlsx はスライスを次の Read で上書きする。文字列はそのまま使える
+			row := rawRow{line: reader.Line(), record: slices.Clone(record)}
+			if raws != nil {
+				row.raw = raws.Raw()
+			}
+			c.rows = append(c.rows, row)
+		}
+	}
+	return c, eof
+}
+
+func parseRow(row rawRow, cols columns, opts Options) outcome {
+	if row.err != nil {
+		return outcome{reject: RowError{Line: row.line, Raw: row.raw, Reason: row.err.Error()}}
+	}
+	station, err := parse(row.record, cols, opts)
+	if err != nil {
+		raw := row.raw
+		if raw == "" {
+			raw = strings.Join(row.record, opts.Profile.Delimiter)
+		}
+		return outcome{
+			reject: RowError{Line: row.line, Raw: raw, Reason: err.Error()},
+			fields: len(row.record),
+		}
+	}
+	return outcome{station: station, ok: true, fields: len(row.record)}
+}
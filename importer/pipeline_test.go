@@ -0,0 +1,297 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"go-form/repo"
+	"io"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 件数を数えるだけの保存先
+type countLoader struct {
+	rows int64
+}
+
+func (c *countLoader) Import(mode repo.ImportMode, values []repo.WeatherStation) (repo.ImportResult, error) {
+	c.rows += int64(len(values))
+	return repo.ImportResult{Inserted: int64(len(values))}, nil
+}
+
+// 読み込んだバイト数を数える
+type countReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// rows 行の city;temperature。i 行目の都市は city<i> なので、行順を確認できる
+func numberedRows(rows int) string {
+	var b strings.Builder
+	for i := range rows {
+		fmt.Fprintf(&b, "city%d;%d.5\n", i, i%50)
+	}
+	return b.String()
+}
+
+func TestParseRowsKeepsInputOrder(t *testing.T) {
+	profile := repo.DefaultImportProfile()
+	plain := profile
+	plain.Quote = repo.QuoteNone
+
+	tests := []struct {
+		name    string
+		rows    int
+		workers int
+		profile repo.ImportProfile
+	}{
+		{"single worker", chunkRows*3 + 7, 1, profile},
+		{"more chunks than workers", chunkRows*20 + 1, 4, profile},
+		{"plain reader", chunkRows*10 + 3, 8, plain},
+		{"shorter than a chunk", 5, 4, profile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := newRecordReader(strings.NewReader(numberedRows(tt.rows)), tt.profile)
+			opts := Options{Profile: tt.profile, Workers: tt.workers}.withDefaults()
+			cols, err := resolveColumns(tt.profile, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			i := 0
+			for o, err := range parseRows(context.Background(), reader, cols, opts) {
+				if err != nil {
+					t.Fatalf("row %d: %v", i, err)
+				}
+				if !o.ok {
+					t.Fatalf("row %d rejected: %s", i, o.reject.Reason)
+				}
+				if want := fmt.Sprintf("city%d", i); o.station.City != want {
+					t.Fatalf("row %d: city = %q, want %q", i, o.station.City, want)
+				}
+				i++
+			}
+			if i != tt.rows {
+				t.Errorf("got %d rows, want %d", i, tt.rows)
+			}
+		})
+	}
+}
+
+func TestParseRowsReportsRejectsInOrder(t *testing.T) {
+	// 3行ごとに気温が不正な行を混ぜる
+	var b strings.Builder
+	rows := chunkRows * 5
+	for i := range rows {
+		if i%3 == 0 {
+			fmt.Fprintf(&b, "city%d;abc\n", i)
+		} else {
+			fmt.Fprintf(&b, "city%d;1.5\n", i)
+		}
+	}
+	profile := repo.DefaultImportProfile()
+	reader := newRecordReader(strings.NewReader(b.String()), profile)
+	cols, _ := resolveColumns(profile, nil)
+
+	line := 0
+	for o, err := range parseRows(context.Background(), reader, cols, Options{Profile: profile, Workers: 4}.withDefaults()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		line++
+		if o.ok == (line%3 == 1) {
+			t.Fatalf("line %d: ok = %v", line, o.ok)
+		}
+		if !o.ok && o.reject.Line != line {
+			t.Fatalf("reject line = %d, want %d", o.reject.Line, line)
+		}
+	}
+	if line != rows {
+		t.Errorf("got %d rows, want %d", line, rows)
+	}
+}
+
+func TestParseRowsStopsEarly(t *testing.T) {
+	data := numberedRows(chunkRows * 200)
+	profile := repo.DefaultImportProfile()
+	cols, _ := resolveColumns(profile, nil)
+
+	tests := []struct {
+		name    string
+		stop    func(cancel context.CancelFunc) bool // 最初の行を受け取ったときに呼ぶ。true の場合はループを抜ける
+		wantErr error
+	}{
+		{"break", func(context.CancelFunc) bool { return true }, nil},
+		{"cancel", func(cancel context.CancelFunc) bool { cancel(); return false }, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			counter := &countReader{r: strings.NewReader(data)}
+			reader := newRecordReader(counter, profile)
+
+			var gotErr error
+			rows := 0
+			for o, err := range parseRows(ctx, reader, cols, Options{Profile: profile, Workers: 2}.withDefaults()) {
+				if err != nil {
+					gotErr = err
+					break
+				}
+				if rows == 0 && tt.stop(cancel) {
+					break
+				}
+				if o.ok {
+					rows++
+				}
+			}
+			if !errors.Is(gotErr, tt.wantErr) {
+				t.Errorf("err = %v, want %v", gotErr, tt.wantErr)
+			}
+			// 受け取り待ちの chunk は Workers の2倍までなので、ファイルの終わりまでは読まない
+			if counter.n >= len(data)/2 {
+				t.Errorf("read %d of %d bytes after stopping", counter.n, len(data))
+			}
+			if rows >= chunkRows*200 {
+				t.Errorf("got all %d rows after stopping", rows)
+			}
+		})
+	}
+}
+
+func TestImportStopsAtErrorBudget(t *testing.T) {
+	// 先頭の正しい行の後は、すべて気温が不正な行
+	var b strings.Builder
+	for i := range 10 {
+		fmt.Fprintf(&b, "city%d;1.5\n", i)
+	}
+	bad := chunkRows * 200
+	for i := range bad {
+		fmt.Fprintf(&b, "bad%d;x\n", i)
+	}
+	data := b.String()
+
+	tests := []struct {
+		name        string
+		maxErrors   int
+		wantRejects int64
+		wantAborted bool
+	}{
+		{"no errors allowed", 0, 1, true},
+		{"budget of 5", 5, 6, true},
+		{"unlimited", -1, int64(bad), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := &countReader{r: strings.NewReader(data)}
+			loader := &countLoader{}
+			report, err := ImportCsv(context.Background(), counter, loader, Options{Mode: repo.ModeAppend, MaxErrors: tt.maxErrors, Workers: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Rejects != tt.wantRejects || report.Aborted != tt.wantAborted {
+				t.Errorf("rejects = %d, aborted = %v, want %d, %v", report.Rejects, report.Aborted, tt.wantRejects, tt.wantAborted)
+			}
+			if loader.rows != 10 || report.Accepted != 10 {
+				t.Errorf("saved %d rows, accepted %d, want 10", loader.rows, report.Accepted)
+			}
+			if tt.wantAborted && counter.n >= len(data)/2 {
+				t.Errorf("read %d of %d bytes after aborting", counter.n, len(data))
+			}
+		})
+	}
+}
+
+var (
+	benchRows     = flag.Int("importbench.rows", 1_000_000, "BenchmarkImportCsv で読み込む行数。10_000_000 で 1BRC と同じ規模の計測になる")
+	benchStations = flag.String("importbench.stations", "../weather_stations.csv", "都市名を取る city;temperature 形式のファイル")
+)
+
+var benchData = sync.OnceValues(func() ([]byte, error) {
+	return generate(*benchStations, *benchRows)
+})
+
+// 並列数ごとの読み込みと検証のスループット。保存は件数を数えるだけで行わない
+//
+//	go test ./importer -run '^$' -bench ImportCsv -importbench.rows 10000000
+func BenchmarkImportCsv(b *testing.B) {
+	data, err := benchData()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for range b.N {
+				loader := &countLoader{}
+				_, err := ImportCsv(context.Background(), bytes.NewReader(data), loader, Options{Mode: repo.ModeAppend, MaxErrors: -1, Workers: workers})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if loader.rows != int64(*benchRows) {
+					b.Fatalf("saved %d rows, want %d", loader.rows, *benchRows)
+				}
+			}
+			b.ReportMetric(float64(*benchRows)*float64(b.N)/b.Elapsed().Seconds(), "rows/s")
+		})
+	}
+}
+
+// stations の都市名を使って、小数点以下1桁の気温を rows 行書き出す
+func generate(stations string, rows int) ([]byte, error) {
+	cities, err := readCities(stations)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	r := rand.New(rand.NewPCG(1, 2))
+	for range rows {
+		fmt.Fprintf(&buf, "%s;%.1f\n", cities[r.IntN(len(cities))], r.Float64()*100-40)
+	}
+	return buf.Bytes(), nil
+}
+
+func readCities(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cities []string
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		city, _, ok := strings.Cut(line, ";")
+		if ok && !seen[city] {
+			seen[city] = true
+			cities = append(cities, city)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(cities) == 0 {
+		return nil, fmt.Errorf("no cities in %s", path)
+	}
+	return cities, nil
+}
@@ -0,0 +1,63 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"go-form/repo"
+)
+
+const (
+	PreviewRows   = 20 // プレビューに表示する先頭の行数
+	PreviewErrors = 20 // プレビューに表示する不正な行の数
+)
+
+// 保存せずに最後まで読み込んだ結果
+type Preview struct {
+	Member string // zip の中のファイル名。zip でない場合は空
+	Report *Report
+	Rows   []repo.WeatherStation // 検証を通過した先頭の行
+	Errors []RowError            // 先頭の不正な行
+	Error  string                // 列が見つからないなど、読み込み自体に失敗した理由
+}
+
+// 先頭の行だけ取っておき、残りは捨てる
+type discardLoader struct {
+	rows []repo.WeatherStation
+}
+
+func (d *discardLoader) Import(mode repo.ImportMode, values []repo.WeatherStation) (repo.ImportResult, error) {
+	if n := PreviewRows - len(d.rows); n > 0 {
+		d.rows = append(d.rows, values[:min(n, len(values))]...)
+	}
+	return repo.ImportResult{}, nil
+}
+
+// path に保存したアップロードを取り込む場合と同じ検証をして、保存はせずに結果を返す
+func PreviewUpload(ctx context.Context, path, member string, opts Options) (*Preview, error) {
+	preview := &Preview{Member: member}
+	file, err := openUpload(path, member)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	loader := &discardLoader{}
+	opts.OnBatch = func(report *Report) {
+		if n := PreviewErrors - len(preview.Errors); n > 0 {
+			preview.Errors = append(preview.Errors, report.Rejected[:min(n, len(report.Rejected))]...)
+		}
+		report.Rejected = report.Rejected[:0]
+	}
+	report, err := Import(ctx, file, loader, opts)
+	if errors.Is(err, context.Canceled) {
+		return nil, err
+	}
+	if err != nil {
+		preview.Error = err.Error()
+	}
+	// 読み込みに失敗した場合は OnBatch が呼ばれないので、残っている不正な行を取り出す
+	opts.OnBatch(report)
+	preview.Report = report
+	preview.Rows = loader.rows
+	return preview, nil
+}
@@ -0,0 +1,71 @@
+package importer
+
+import (
+	"fmt"
+	"go-form/repo"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// プロファイルに従って1行ずつフィールドに分割する
+type recordReader interface {
+	Read() ([]string, error)
+	Line() int // 直前に読んだ行の行番号
+}
+
+// CSV は textReader で読む。JSON と xlsx はそれぞれの Reader を使う
+func newRecordReader(r io.Reader, profile repo.ImportProfile) recordReader {
+	return newTextReader(r, profile)
+}
+
+// 各項目が何番目の列にあるか。観測日時の列がない場合は -1
+type columns struct {
+	city        int
+	temperature int
+	measuredAt  int
+}
+
+// 読み込むのに必要な列の数
+func (c columns) required() int {
+	return max(c.city, c.temperature) + 1
+}
+
+// プロファイルの列指定を列番号に解決する。見出し行がない場合 header は nil
+func resolveColumns(profile repo.ImportProfile, header []string) (columns, error) {
+	city, err := resolveColumn(profile.CityColumn, header)
+	if err != nil {
+		return columns{}, err
+	}
+	temperature, err := resolveColumn(profile.TemperatureColumn, header)
+	if err != nil {
+		return columns{}, err
+	}
+	measuredAt := -1
+	if profile.MeasuredAtColumn != "" {
+		if measuredAt, err = resolveColumn(profile.MeasuredAtColumn, header); err != nil {
+			return columns{}, err
+		}
+	}
+	return columns{city: city, temperature: temperature, measuredAt: measuredAt}, nil
+}
+
+// 数字の場合は1始まりの列番号、それ以外は見出しの名前（大文字小文字を区別しない）として扱う
+func resolveColumn(column string, header []string) (int, error) {
+	column = strings.TrimSpace(column)
+	if n, err := strconv.Atoi(column); err == nil {
+		if n < 1 {
+			return 0, fmt.Errorf("invalid column number %d", n)
+		}
+		return n - 1, nil
+	}
+	if header == nil {
+		return 0, fmt.Errorf("column %q requires a header row", column)
+	}
+	for i, name := range header {
+		if strings.EqualFold(strings.TrimSpace(name), column) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("column %q not found in header", column)
+}
@@ -0,0 +1,211 @@
+package importer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 途中まで受け取ったアップロードの保存ディレクトリ
+// ファイルは {Id}.part、設定は {Id}.json に保存し、受け取り終わったら UploadDir/{Id} に移す
+var ResumableDir = filepath.Join(UploadDir, "resumable")
+
+// 分割して送られるアップロード。受け取った大きさ（Offset）は保存したファイルの大きさから求める
+type Resumable struct {
+	Id        string
+	UserId    string
+	Length    int64             // ファイル全体の大きさ
+	Metadata  map[string]string // 取り込みの設定。Upload-Metadata の値
+	CreatedAt time.Time
+}
+
+var (
+	ErrResumableNotFound = errors.New("upload not found")
+	ErrOffsetMismatch    = errors.New("upload offset does not match")
+	ErrUploadBusy        = errors.New("upload is being written by another request")
+	ErrExceedsLength     = errors.New("upload exceeds the declared length")
+)
+
+// 同じアップロードに同時に書き込まないよう、書き込み中の ID を記録する
+var (
+	resumableMu   sync.Mutex
+	resumableBusy = map[string]bool{}
+)
+
+// 書き込み中の印を付ける。既に付いている場合は ErrUploadBusy を返す
+func (u *Resumable) lock() (func(), error) {
+	resumableMu.Lock()
+	defer resumableMu.Unlock()
+	if resumableBusy[u.Id] {
+		return nil, ErrUploadBusy
+	}
+	resumableBusy[u.Id] = true
+	return func() {
+		resumableMu.Lock()
+		delete(resumableBusy, u.Id)
+		resumableMu.Unlock()
+	}, nil
+}
+
+// 空のファイルを作って受け取りを始める
+func CreateResumable(u *Resumable) error {
+	if err := os.MkdirAll(ResumableDir, 0755); err != nil {
+		return fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(u.partPath(), nil, 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(u.metaPath(), data, 0644); err != nil {
+		os.Remove(u.partPath())
+		return err
+	}
+	return nil
+}
+
+func LoadResumable(id string) (*Resumable, error) {
+	if !validId(id) {
+		return nil, ErrResumableNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(ResumableDir, id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrResumableNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var u Resumable
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, fmt.Errorf("invalid upload %s: %w", id, err)
+	}
+	return &u, nil
+}
+
+func (u *Resumable) partPath() string {
+	return filepath.Join(ResumableDir, u.Id+".part")
+}
+
+func (u *Resumable) metaPath() string {
+	return filepath.Join(ResumableDir, u.Id+".json")
+}
+
+// 受け取り済みの大きさ
+func (u *Resumable) Offset() (int64, error) {
+	info, err := os.Stat(u.partPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, ErrResumableNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// offset の位置から r を書き足して、受け取り済みの大きさを返す
+// offset が受け取り済みの大きさと異なる場合は ErrOffsetMismatch を返す
+// 途中で r の読み込みに失敗しても、それまでに受け取った分は残すので続きから再開できる
+func (u *Resumable) Append(offset int64, r io.Reader) (int64, error) {
+	unlock, err := u.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	current, err := u.Offset()
+	if err != nil {
+		return 0, err
+	}
+	if offset != current {
+		return current, ErrOffsetMismatch
+	}
+
+	file, err := os.OpenFile(u.partPath(), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return current, err
+	}
+	defer file.Close()
+	// 宣言した大きさを超える分は書き込まない
+	n, err := io.Copy(file, io.LimitReader(r, u.Length-current))
+	current += n
+	if err != nil {
+		return current, err
+	}
+	if current == u.Length {
+		var extra [1]byte
+		if n, _ := r.Read(extra[:]); n > 0 {
+			return current, ErrExceedsLength
+		}
+	}
+	return current, file.Close()
+}
+
+// 全て受け取ったファイルを UploadDir/{Id} に移し、パスと SHA-256 を返す
+// 以降は SaveUpload で保存したファイルと同じように扱う
+func (u *Resumable) Complete() (string, string, error) {
+	unlock, err := u.lock()
+	if err != nil {
+		return "", "", err
+	}
+	defer unlock()
+
+	file, err := os.Open(u.partPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return "", "", ErrResumableNotFound // 別のリクエストが先に完了させた
+	}
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", "", err
+	}
+	file.Close()
+
+	path := filepath.Join(UploadDir, u.Id)
+	if err := os.Rename(u.partPath(), path); err != nil {
+		return "", "", err
+	}
+	os.Remove(u.metaPath())
+	return path, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// 受け取りを中止してファイルと設定を削除する
+func (u *Resumable) Terminate() error {
+	err := os.Remove(u.partPath())
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	return errors.Join(err, os.Remove(u.metaPath()))
+}
+
+// maxAge の間書き込みがなかった途中のアップロードを削除する
+func CleanResumable(maxAge time.Duration) (int, error) {
+	matches, err := filepath.Glob(filepath.Join(ResumableDir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, meta := range matches {
+		u := &Resumable{Id: filepath.Base(meta[:len(meta)-len(".json")])}
+		info, err := os.Stat(u.partPath())
+		if err == nil && time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+		if err := u.Terminate(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
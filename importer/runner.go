@@ -0,0 +1,212 @@
+package importer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-form/repo"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const UploadDir = "./tmp/uploads" // 取り込み待ちのファイルの保存ディレクトリ
+
+// アップロードできるファイルの大きさの上限。起動時に UPLOAD_MAX_BYTES で変更できる
+var MaxUploadBytes int64 = 8 << 30
+
+// アップロードされたファイルを取り込みジョブ用に保存する。保存したパスと SHA-256 を返す
+func SaveUpload(importId string, r io.Reader) (string, string, error) {
+	if err := os.MkdirAll(UploadDir, 0755); err != nil {
+		return "", "", fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	path := filepath.Join(UploadDir, importId)
+	file, err := os.Create(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(file, io.TeeReader(r, hash)); err != nil {
+		os.Remove(path)
+		return "", "", err
+	}
+	return path, hex.EncodeToString(hash.Sum(nil)), file.Close()
+}
+
+// 取り込みジョブをバックグラウンドで実行する。同時に実行するジョブの数は concurrency までに制限する
+type Runner struct {
+	stations repo.WeatherStationStore
+	imports  repo.ImportStore
+	slots    chan struct{}
+
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+	refs    map[string]int // zip の各ファイルのジョブは同じアップロードを読むので、全て終わってから削除する
+}
+
+func NewRunner(stations repo.WeatherStationStore, imports repo.ImportStore, concurrency int) *Runner {
+	return &Runner{
+		stations: stations,
+		imports:  imports,
+		slots:    make(chan struct{}, max(concurrency, 1)),
+		cancels:  make(map[string]context.CancelFunc),
+		refs:     make(map[string]int),
+	}
+}
+
+// path のファイルを取り込むジョブを開始する。imp は ImportStore に登録済みであること
+// ファイルはジョブが終わると削除される
+func (r *Runner) Start(imp *repo.Import, path string, opts Options) {
+	r.mu.Lock()
+	r.refs[path]++
+	r.mu.Unlock()
+	r.start(imp, path, "", opts)
+}
+
+// zip の中のファイルごとのジョブをまとめて開始する。imps[i] が members[i] を取り込む（Members を参照）
+// zip は全てのジョブが終わると削除される
+func (r *Runner) StartArchive(imps []*repo.Import, path string, members []string, opts Options) {
+	// 先に終わったジョブが zip を削除しないよう、全てのジョブの分を先に数える
+	r.mu.Lock()
+	r.refs[path] += len(imps)
+	r.mu.Unlock()
+	for i, imp := range imps {
+		opts.ImportId = imp.Id
+		r.start(imp, path, members[i], opts)
+	}
+}
+
+func (r *Runner) start(imp *repo.Import, path, member string, opts Options) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.mu.Lock()
+	r.cancels[imp.Id] = cancel
+	r.mu.Unlock()
+
+	go func() {
+		defer func() {
+			r.mu.Lock()
+			delete(r.cancels, imp.Id)
+			r.refs[path]--
+			last := r.refs[path] == 0
+			if last {
+				delete(r.refs, path)
+			}
+			r.mu.Unlock()
+			cancel()
+			if !last {
+				return
+			}
+			if err := os.Remove(path); err != nil {
+				log.Printf("Upload Remove Error: %v", err)
+			}
+		}()
+		r.run(ctx, imp, path, member, opts)
+	}()
+}
+
+// 実行中または待機中のジョブをキャンセルする。該当するジョブがない場合は false を返す
+func (r *Runner) Cancel(id string) bool {
+	r.mu.Lock()
+	cancel, ok := r.cancels[id]
+	r.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+func (r *Runner) run(ctx context.Context, imp *repo.Import, path, member string, opts Options) {
+	// 空きが出るまで待機する
+	select {
+	case r.slots <- struct{}{}:
+		defer func() { <-r.slots }()
+	case <-ctx.Done():
+		imp.Status = repo.StatusCancelled
+		r.finish(imp)
+		return
+	}
+
+	started := time.Now()
+	imp.Status = repo.StatusRunning
+	imp.StartedAt = &started
+	r.update(imp)
+
+	file, err := openUpload(path, member)
+	if err != nil {
+		imp.Status = repo.StatusFailed
+		imp.Error = err.Error()
+		r.finish(imp)
+		return
+	}
+	defer file.Close()
+
+	// 途中で失敗した場合に一部の行だけが残らないよう、1つのトランザクションで取り込む
+	tx, err := r.stations.Begin()
+	if err != nil {
+		imp.Status = repo.StatusFailed
+		imp.Error = err.Error()
+		r.finish(imp)
+		return
+	}
+
+	// 不正な行はバッチごとに保存してメモリに溜めない
+	opts.OnBatch = func(report *Report) {
+		if err := r.imports.AddErrors(imp.Id, report.Rejected); err != nil {
+			log.Printf("Import Error Save Error: %v", err)
+		}
+		report.Rejected = report.Rejected[:0]
+		imp.Encoding = string(report.Encoding)
+		imp.RowsProcessed = report.Processed()
+		imp.RowsRejected = report.Rejects
+		imp.Result = report.Result
+		r.update(imp)
+	}
+	report, err := Import(ctx, file, tx, opts)
+	opts.OnBatch(report)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		imp.Status = repo.StatusCancelled
+	case err != nil:
+		imp.Status = repo.StatusFailed
+		imp.Error = err.Error()
+	case report.Aborted:
+		imp.Status = repo.StatusFailed
+		imp.Error = "aborted: too many invalid rows"
+	default:
+		if err := tx.Commit(); err != nil {
+			imp.Status = repo.StatusFailed
+			imp.Error = err.Error()
+			imp.Result = repo.ImportResult{}
+		} else {
+			imp.Status = repo.StatusSucceeded
+		}
+		r.finish(imp)
+		return
+	}
+	// 保存した行は全て取り消されるので件数も戻す
+	if err := tx.Rollback(); err != nil {
+		log.Printf("Import Rollback Error: %v", err)
+	}
+	imp.Result = repo.ImportResult{}
+	r.finish(imp)
+}
+
+// 終了日時を記録して保存する
+func (r *Runner) finish(imp *repo.Import) {
+	finished := time.Now()
+	imp.FinishedAt = &finished
+	r.update(imp)
+}
+
+func (r *Runner) update(imp *repo.Import) {
+	if err := r.imports.Update(imp); err != nil {
+		log.Printf("Import Update Error: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package importer
+
+import (
+	"go-form/repo"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 取り込みジョブが終わるまで待つ
+func waitImport(t *testing.T, imports repo.ImportStore, id string) *repo.Import {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		imp, err := imports.FindById(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if imp.FinishedAt != nil {
+			return imp
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("import did not finish")
+	return nil
+}
+
+func TestRunnerRollsBackFailedImport(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		maxErrors  int
+		wantStatus repo.ImportStatus
+		wantRows   int64
+	}{
+		{"succeeded", "Tokyo;10.5\nOsaka;20.5\nTokyo;bad\n", -1, repo.StatusSucceeded, 2},
+		// 不正な行で中断した場合は、それまでに保存した行も残さない
+		{"aborted", "Tokyo;10.5\nOsaka;20.5\nTokyo;bad\nOsaka;bad\n", 1, repo.StatusFailed, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "upload.csv")
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			stations := repo.NewMemoryWeatherStationStore()
+			imports := repo.NewMemoryImportStore()
+			imp := &repo.Import{UserId: "alice", Filename: "upload.csv", Mode: repo.ModeAppend}
+			if err := imports.Create(imp); err != nil {
+				t.Fatal(err)
+			}
+
+			NewRunner(stations, imports, 1).Start(imp, path, Options{
+				Mode:       repo.ModeAppend,
+				ImportId:   imp.Id,
+				UploadedAt: time.Now(),
+				MaxErrors:  tt.maxErrors,
+				BatchSize:  1, // 中断する前に保存した行があるようにする
+			})
+			got := waitImport(t, imports, imp.Id)
+
+			if got.Status != tt.wantStatus {
+				t.Fatalf("status = %q (%s), want %q", got.Status, got.Error, tt.wantStatus)
+			}
+			if got.Result.Inserted != tt.wantRows {
+				t.Errorf("inserted = %d, want %d", got.Result.Inserted, tt.wantRows)
+			}
+			page, err := stations.List(repo.ListOptions{ImportId: imp.Id})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(page.Items)) != tt.wantRows {
+				t.Errorf("%d rows saved, want %d", len(page.Items), tt.wantRows)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 保存したアップロードと取り込みの設定
+// プレビューのあと確定か破棄を待つ間は、ファイルは UploadDir/{Id}、設定は UploadDir/{Id}.json に保存する
+type Staged struct {
+	Id        string
+	UserId    string
+	Filename  string
+	Checksum  string
+	Members   []string // Members の結果
+	Options   Options
+	CreatedAt time.Time
+}
+
+var ErrStagedNotFound = errors.New("staged upload not found")
+
+// SaveUpload で保存したファイルの設定を保存する
+func Stage(s *Staged) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.metaPath(), data, 0644)
+}
+
+func LoadStaged(id string) (*Staged, error) {
+	if !validId(id) {
+		return nil, ErrStagedNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(UploadDir, id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrStagedNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s Staged
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid staged upload %s: %w", id, err)
+	}
+	return &s, nil
+}
+
+// アップロードされたファイルのパス
+func (s *Staged) Path() string {
+	return filepath.Join(UploadDir, s.Id)
+}
+
+func (s *Staged) metaPath() string {
+	return filepath.Join(UploadDir, s.Id+".json")
+}
+
+// 確定した。ファイルは取り込みジョブに引き継ぐので設定だけ削除する
+func (s *Staged) Commit() error {
+	return os.Remove(s.metaPath())
+}
+
+// 破棄した。ファイルと設定を削除する
+func (s *Staged) Discard() error {
+	return errors.Join(os.Remove(s.Path()), os.Remove(s.metaPath()))
+}
+
+// maxAge より前にプレビューしたまま放置されたアップロードを削除する
+func CleanStaged(maxAge time.Duration) (int, error) {
+	matches, err := filepath.Glob(filepath.Join(UploadDir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, meta := range matches {
+		s, err := LoadStaged(strings.TrimSuffix(filepath.Base(meta), ".json"))
+		if err != nil || time.Since(s.CreatedAt) < maxAge {
+			continue
+		}
+		if err := s.Discard(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// パスに使うので UUID の文字だけを許す
+func validId(id string) bool {
+	if len(id) != 36 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,229 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"go-form/repo"
+	"io"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+const maxPlainLine = 1024 * 1024 // 引用符を解釈しない場合の1行の上限
+
+// CSV を行の区切りだけ探して読む
+// 先頭の行と見出し行は Read で1行ずつ分割し、残りは readChunk で元のテキストのまま切り出して
+// 列への分割（split）は検証する goroutine で行う
+type textReader struct {
+	r         *bufio.Reader
+	profile   repo.ImportProfile
+	comma     []byte
+	trimSpace bool
+	lines     int      // 読み終えた行数
+	line      int      // Read で直前に返した行の行番号
+	pending   []rawRow // Read で分割したが、まだ返していない行
+	size      int      // 直前の chunk のバイト数。次の chunk の容量の目安
+}
+
+func newTextReader(r io.Reader, profile repo.ImportProfile) *textReader {
+	comma := profile.DelimiterRune()
+	return &textReader{
+		r:         bufio.NewReaderSize(r, 64*1024),
+		profile:   profile,
+		comma:     []byte(string(comma)),
+		trimSpace: !unicode.IsSpace(comma), // 区切り文字が空白の場合は区切りまで消えてしまうので使わない
+	}
+}
+
+func (t *textReader) quoted() bool {
+	return t.profile.Quote != repo.QuoteNone
+}
+
+func (t *textReader) Read() ([]string, error) {
+	for len(t.pending) == 0 {
+		line := t.lines + 1
+		text, err := t.readRecord(nil)
+		if err != nil {
+			return nil, err
+		}
+		t.pending = t.split(nil, text, line) // 空行とコメント行は何も返らない
+	}
+	row := t.pending[0]
+	t.pending = t.pending[1:]
+	t.line = row.line
+	if row.err != nil {
+		return nil, &csv.ParseError{StartLine: row.line, Line: row.line, Err: row.err}
+	}
+	return row.record, nil
+}
+
+func (t *textReader) Line() int {
+	return t.line
+}
+
+// chunkRows 行分の元のテキストを切り出す。Read で分割したまま返していない行は rows に入れて先に渡す
+func (t *textReader) readChunk() (c chunk, eof bool) {
+	c.rows, t.pending = t.pending, nil
+	c.line = t.lines + 1
+	c.text = make([]byte, 0, t.size+t.size/8)
+	for range chunkRows {
+		var err error
+		c.text, err = t.readRecord(c.text)
+		if err == io.EOF {
+			eof = true
+			break
+		}
+		if err != nil {
+			c.err = fmt.Errorf("failed to read upload: %w", err)
+			break
+		}
+	}
+	t.size = len(c.text)
+	return c, eof
+}
+
+// 1行分（引用符の中に改行がある場合は複数行）のテキストを buf に追加する
+// 改行も含めて追加するので、続けて呼ぶと元のテキストがそのままつながる
+func (t *textReader) readRecord(buf []byte) ([]byte, error) {
+	start := len(buf)
+	inQuote := false
+	for {
+		lineStart := len(buf)
+		var err error
+		buf, err = t.readLine(buf)
+		if err == io.EOF && len(buf) > start {
+			return buf, nil // 引用符が閉じないまま終わった。分割するときにエラーになる
+		}
+		if err != nil {
+			return buf[:start], err
+		}
+		t.lines++
+		line := buf[lineStart:]
+		if !t.quoted() {
+			if len(line) > maxPlainLine {
+				return buf[:start], bufio.ErrTooLong
+			}
+			return buf, nil
+		}
+		// コメント行は引用符を解釈しない
+		if !inQuote && t.profile.Comment != "" && bytes.HasPrefix(line, []byte(t.profile.Comment)) {
+			return buf, nil
+		}
+		if inQuote = t.endsInQuote(line, inQuote); !inQuote {
+			return buf, nil
+		}
+	}
+}
+
+// 改行までの1行を buf に追加する。最後の行は改行がなくてもよい
+func (t *textReader) readLine(buf []byte) ([]byte, error) {
+	start := len(buf)
+	for {
+		line, err := t.r.ReadSlice('\n')
+		buf = append(buf, line...)
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF && len(buf) > start:
+			return buf, nil
+		default:
+			return buf, err
+		}
+	}
+}
+
+// line の終わりで引用符の中にいるか。inQuote は前の行の終わりで引用符の中だったか
+// csv.Reader と同じく、フィールドの先頭の " だけを引用符として扱い、"" は " の文字とみなす
+// 不正な引用符がある場合、csv.Reader はその行で打ち切ってエラーにするので、引用符の外とみなす
+func (t *textReader) endsInQuote(line []byte, inQuote bool) bool {
+	if !inQuote && bytes.IndexByte(line, '"') < 0 {
+		return false // ほとんどの行は引用符を含まない
+	}
+	for {
+		if !inQuote {
+			if t.trimSpace {
+				line = bytes.TrimLeftFunc(line, unicode.IsSpace)
+			}
+			if len(line) == 0 || line[0] != '"' {
+				// 引用符で囲まれていないフィールドは区切り文字まで読み飛ばす
+				i := bytes.Index(line, t.comma)
+				if i < 0 {
+					return false
+				}
+				line = line[i+len(t.comma):]
+				continue
+			}
+			line, inQuote = line[1:], true
+		}
+		i := bytes.IndexByte(line, '"')
+		if i < 0 {
+			return true
+		}
+		line = line[i+1:]
+		switch {
+		case len(line) > 0 && line[0] == '"':
+			line = line[1:]
+		case bytes.HasPrefix(line, t.comma):
+			line, inQuote = line[len(t.comma):], false
+		case len(line) == 0 || string(line) == "\n" || string(line) == "\r\n":
+			return false
+		case t.profile.Quote == repo.QuoteLazy:
+			// 値の途中の " はそのまま値に含める
+		default:
+			return false
+		}
+	}
+}
+
+// readRecord で読んだテキストを列に分割して rows に追加する。line はテキストの先頭の行番号
+// 検証する goroutine から並列に呼ばれるので、textReader のフィールドは書き換えない
+func (t *textReader) split(rows []rawRow, text []byte, line int) []rawRow {
+	rows = slices.Grow(rows, bytes.Count(text, []byte{'\n'})+1)
+	if !t.quoted() {
+		sep := t.profile.Delimiter
+		for ; len(text) > 0; line++ {
+			var l []byte
+			if i := bytes.IndexByte(text, '\n'); i >= 0 {
+				l, text = text[:i], text[i+1:]
+			} else {
+				l, text = text, nil
+			}
+			s := strings.TrimSuffix(string(l), "\r")
+			// csv.Reader と同じく空行とコメント行は読み飛ばす
+			if s == "" || (t.profile.Comment != "" && strings.HasPrefix(s, t.profile.Comment)) {
+				continue
+			}
+			rows = append(rows, rawRow{line: line, record: strings.Split(s, sep)})
+		}
+		return rows
+	}
+
+	reader := csv.NewReader(bytes.NewReader(text))
+	reader.Comma = t.profile.DelimiterRune()
+	reader.Comment = t.profile.CommentRune()
+	reader.FieldsPerRecord = -1                           // 列の数は parse でチェックする
+	reader.LazyQuotes = t.profile.Quote == repo.QuoteLazy // true の場合、"" が値の途中に "180"cm のようになっていてもエラーにならない
+	reader.TrimLeadingSpace = t.trimSpace
+	for {
+		record, err := reader.Read()
+		var parseErr *csv.ParseError
+		switch {
+		case err == io.EOF:
+			return rows
+		case errors.As(err, &parseErr):
+			// 引用符の不正などは行ごと読めないので、その行を記録して次の行へ進む
+			rows = append(rows, rawRow{line: line + parseErr.StartLine - 1, err: parseErr.Err})
+		case err != nil:
+			// メモリ上のテキストなので読み込み自体は失敗しない
+			rows = append(rows, rawRow{line: line, err: err})
+			return rows
+		default:
+			l, _ := reader.FieldPos(0)
+			rows = append(rows, rawRow{line: line + l - 1, record: record})
+		}
+	}
+}
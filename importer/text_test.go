@@ -0,0 +1,134 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"go-form/repo"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// 引用符の中の改行や区切り文字、不正な引用符を含む行を rows 行以上つなげる
+func trickyRows(rows int) string {
+	samples := []string{
+		"city%d;1.5\n",
+		"\"Sao ; Paulo %d\";2.5\n",
+		"\"multi\nline %d\";3.5\n",
+		"\"a \"\"quoted\"\" name %d\";4.5\r\n",
+		"  \"spaced %d\" ;5\n",
+		"# comment \"%d\n",
+		"\n",
+		"bad\"quote%d;1\n",
+		"\"closed\"x%d;1\n",
+		"\"field;\n\n%d\";\"next\nvalue\"\n",
+	}
+	var b strings.Builder
+	for i := range rows {
+		s := samples[i%len(samples)]
+		if strings.Contains(s, "%d") {
+			s = fmt.Sprintf(s, i)
+		}
+		b.WriteString(s)
+	}
+	b.WriteString("\"unclosed;1\nlast")
+	return b.String()
+}
+
+// csv.Reader で先頭から1行ずつ読んだ結果
+func csvRows(t *testing.T, data string, profile repo.ImportProfile) []rawRow {
+	t.Helper()
+	reader := csv.NewReader(strings.NewReader(data))
+	reader.Comma = profile.DelimiterRune()
+	reader.Comment = profile.CommentRune()
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = profile.Quote == repo.QuoteLazy
+	reader.TrimLeadingSpace = !unicode.IsSpace(reader.Comma)
+	var rows []rawRow
+	for {
+		record, err := reader.Read()
+		var parseErr *csv.ParseError
+		switch {
+		case err == io.EOF:
+			return rows
+		case errors.As(err, &parseErr):
+			rows = append(rows, rawRow{line: parseErr.StartLine, err: parseErr.Err})
+		case err != nil:
+			t.Fatal(err)
+		default:
+			line, _ := reader.FieldPos(0)
+			rows = append(rows, rawRow{line: line, record: record})
+		}
+	}
+}
+
+func TestTextReaderMatchesCsvReader(t *testing.T) {
+	for _, quote := range []repo.QuoteMode{repo.QuoteLazy, repo.QuoteStrict} {
+		t.Run(string(quote), func(t *testing.T) {
+			profile := repo.DefaultImportProfile()
+			profile.Quote = quote
+			data := trickyRows(chunkRows*5 + 5)
+			want := csvRows(t, data, profile)
+
+			// 見出しのように先頭の数行は Read で読み、残りは chunk に切り出して分割する
+			reader := newTextReader(strings.NewReader(data), profile)
+			var got []rawRow
+			for range 3 {
+				record, err := reader.Read()
+				var parseErr *csv.ParseError
+				if errors.As(err, &parseErr) {
+					got = append(got, rawRow{line: parseErr.StartLine, err: parseErr.Err})
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, rawRow{line: reader.Line(), record: record})
+			}
+			chunks := 0
+			for eof := false; !eof; {
+				var c chunk
+				c, eof = reader.readChunk()
+				if c.err != nil {
+					t.Fatal(c.err)
+				}
+				got = reader.split(append(got, c.rows...), c.text, c.line)
+				chunks++
+			}
+			if chunks < 3 {
+				t.Fatalf("read %d chunks, want a boundary inside the data", chunks)
+			}
+
+			if len(got) != len(want) {
+				t.Fatalf("got %d rows, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if got[i].line != want[i].line || !reflect.DeepEqual(got[i].record, want[i].record) || fmt.Sprint(got[i].err) != fmt.Sprint(want[i].err) {
+					t.Fatalf("row %d = %+v, want %+v", i, got[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTextReaderSplitsPlainLines(t *testing.T) {
+	profile := repo.DefaultImportProfile()
+	profile.Quote = repo.QuoteNone
+	data := "# comment\n\"Sao;Paulo\";1.5\r\n\nbad\"quote;2\nlast;3"
+	reader := newTextReader(strings.NewReader(data), profile)
+	c, eof := reader.readChunk()
+	if !eof || c.err != nil {
+		t.Fatalf("eof = %v, err = %v", eof, c.err)
+	}
+	want := []rawRow{
+		{line: 2, record: []string{`"Sao`, `Paulo"`, "1.5"}},
+		{line: 4, record: []string{`bad"quote`, "2"}},
+		{line: 5, record: []string{"last", "3"}},
+	}
+	if got := reader.split(nil, c.text, c.line); !reflect.DeepEqual(got, want) {
+		t.Errorf("rows = %+v, want %+v", got, want)
+	}
+}
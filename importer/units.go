@@ -0,0 +1,95 @@
+package importer
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// アップロードされた気温の単位。保存するときは摂氏に変換する
+type Unit string
+
+const (
+	Celsius    Unit = "C"
+	Fahrenheit Unit = "F"
+	Kelvin     Unit = "K"
+)
+
+// アップロードで選べる単位
+var Units = []Unit{Celsius, Fahrenheit, Kelvin}
+
+func (u Unit) Label() string {
+	switch u {
+	case Celsius, "":
+		return "℃"
+	case Fahrenheit:
+		return "℉"
+	case Kelvin:
+		return "K"
+	}
+	return string(u)
+}
+
+// 空の場合は Celsius。°C や celsius のような書き方も受け付ける
+func ParseUnit(s string) (Unit, error) {
+	switch strings.ToLower(strings.TrimPrefix(strings.TrimSpace(s), "°")) {
+	case "", "c", "celsius", "℃":
+		return Celsius, nil
+	case "f", "fahrenheit", "℉":
+		return Fahrenheit, nil
+	case "k", "kelvin":
+		return Kelvin, nil
+	}
+	return "", fmt.Errorf("unknown unit %q", s)
+}
+
+// 摂氏に変換する
+func (u Unit) toCelsius(v float64) float64 {
+	switch u {
+	case Fahrenheit:
+		return (v - 32) * 5 / 9
+	case Kelvin:
+		return v - 273.15
+	}
+	return v
+}
+
+// 妥当な気温（摂氏）の既定値。観測史上の最低・最高気温に余裕を持たせた範囲
+const (
+	DefaultMinTemperature = -100.0
+	DefaultMaxTemperature = 100.0
+)
+
+// 取り込む値の妥当性の確認。範囲外や桁数の多すぎる値は 9999 のような欠測値や単位の誤りとみなして不正な行にする
+// 0 も上限・下限として使えるよう、確認しない項目は nil にする
+type Checks struct {
+	Min, Max *float64 // 摂氏での妥当な範囲。nil の側は確認しない
+	Decimals *int     // 小数点以下の桁数の上限。nil の場合は確認しない
+}
+
+// 既定の範囲で確認して、桁数は確認しない
+func DefaultChecks() Checks {
+	minTemp, maxTemp := DefaultMinTemperature, DefaultMaxTemperature
+	return Checks{Min: &minTemp, Max: &maxTemp}
+}
+
+// value はファイルに書かれたままの値、celsius は摂氏に変換した値
+func (c Checks) check(value string, celsius float64) error {
+	if c.Decimals != nil {
+		if _, fraction, ok := strings.Cut(value, "."); ok && len(strings.TrimRight(fraction, "0")) > *c.Decimals {
+			return fmt.Errorf("temperature %q has more than %d decimal places", value, *c.Decimals)
+		}
+	}
+	if c.Min != nil && celsius < *c.Min {
+		return fmt.Errorf("temperature %q (%.1f°C) is below the plausible minimum %g°C", value, celsius, *c.Min)
+	}
+	if c.Max != nil && celsius > *c.Max {
+		return fmt.Errorf("temperature %q (%.1f°C) is above the plausible maximum %g°C", value, celsius, *c.Max)
+	}
+	return nil
+}
+
+// 変換で増えた桁を保存する桁数（NUMERIC(10, 4)）に丸める
+func roundCelsius(v float64) float64 {
+	return math.Round(v*1e4) / 1e4
+}
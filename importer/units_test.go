@@ -0,0 +1,40 @@
+package importer
+
+import "testing"
+
+func TestChecks(t *testing.T) {
+	zero, one := 0.0, 1.0
+	noDecimals, oneDecimal := 0, 1
+	tests := []struct {
+		name    string
+		checks  Checks
+		value   string
+		celsius float64
+		wantErr bool
+	}{
+		{"default range", DefaultChecks(), "35.5", 35.5, false},
+		{"above the default range", DefaultChecks(), "9999", 9999, true},
+		{"below the default range", DefaultChecks(), "-9999", -9999, true},
+		{"no checks", Checks{}, "9999.123", 9999.123, false},
+		{"zero decimals allows integers", Checks{Decimals: &noDecimals}, "12", 12, false},
+		{"zero decimals allows trailing zeros", Checks{Decimals: &noDecimals}, "12.00", 12, false},
+		{"zero decimals rejects a fraction", Checks{Decimals: &noDecimals}, "12.5", 12.5, true},
+		{"one decimal", Checks{Decimals: &oneDecimal}, "12.5", 12.5, false},
+		{"more than one decimal", Checks{Decimals: &oneDecimal}, "12.25", 12.25, true},
+		{"zero range allows zero", Checks{Min: &zero, Max: &zero}, "0", 0, false},
+		{"zero range rejects above", Checks{Min: &zero, Max: &zero}, "0.1", 0.1, true},
+		{"zero range rejects below", Checks{Min: &zero, Max: &zero}, "-0.1", -0.1, true},
+		{"minimum only", Checks{Min: &one}, "9999", 9999, false},
+		{"below the minimum only", Checks{Min: &one}, "0.5", 0.5, true},
+		// 範囲は摂氏に変換した値で確認する
+		{"converted value", Checks{Min: &zero, Max: &one}, "32", Fahrenheit.toCelsius(32), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.checks.check(tt.value, tt.celsius)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("check(%q) = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"go-form/core/xlsx"
+	"io"
+	"os"
+)
+
+// xlsx のシートを CSV と同じく1行ずつ返す
+// 空の行は xlsx に含まれず行を数えても位置が合わないので、skip 行目までは行番号で読み飛ばす
+type sheetReader struct {
+	rows *xlsx.Rows
+	skip int
+}
+
+func (s *sheetReader) Read() ([]string, error) {
+	for {
+		record, err := s.rows.Next()
+		if err != nil || s.rows.Row() > s.skip {
+			return record, err
+		}
+	}
+}
+
+func (s *sheetReader) Line() int {
+	return s.rows.Row()
+}
+
+// xlsx の opts.Sheet のシートを読み込んで保存する。列の指定、見出し行、読み飛ばす行は opts.Profile に従う
+// xlsx は zip なので、r がファイルでない場合は一時ファイルに書き出してから読む
+func ImportXlsx(ctx context.Context, r io.Reader, stations Loader, opts Options) (*Report, error) {
+	opts = opts.withDefaults()
+	report := &Report{ImportId: opts.ImportId, Mode: opts.Mode}
+
+	file, ok := r.(*os.File)
+	if !ok {
+		tmp, err := os.CreateTemp("", "upload-*.xlsx")
+		if err != nil {
+			return report, err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if _, err := io.Copy(tmp, r); err != nil {
+			return report, fmt.Errorf("failed to read xlsx: %w", err)
+		}
+		file = tmp
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return report, err
+	}
+
+	workbook, err := xlsx.Open(file, info.Size(), MaxUncompressedBytes)
+	if err != nil {
+		return report, fmt.Errorf("failed to read xlsx: %w", err)
+	}
+	sheet, err := workbook.Sheet(opts.Sheet)
+	if err != nil {
+		return report, err
+	}
+	report.Sheet = sheet.Name
+	rows, err := workbook.Rows(sheet)
+	if err != nil {
+		return report, fmt.Errorf("failed to read xlsx: %w", err)
+	}
+	defer rows.Close()
+
+	reader := &sheetReader{rows: rows, skip: opts.Profile.SkipRows}
+	opts.Profile.SkipRows = 0
+	return report, importTable(ctx, reader, stations, opts, report)
+}
@@ -3,28 +3,132 @@ package main
 import (
 	"go-form/controller/csv"
 	"go-form/controller/home"
+	"go-form/controller/importprofiles"
+	"go-form/controller/imports"
+	"go-form/controller/profile"
+	"go-form/controller/settings"
 	"go-form/controller/signin"
 	"go-form/controller/signout"
 	"go-form/controller/signup"
+	"go-form/controller/stations"
+	"go-form/controller/users"
 	"go-form/core/csrf"
+	"go-form/core/database"
+	"go-form/importer"
+	"go-form/repo"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func main() {
+	userStore, stationStore, importStore, profileStore := stores()
+
+	// 前回の起動中に終わらなかった取り込みは再開できないので失敗にする
+	if n, err := importStore.FailUnfinished("interrupted by server restart"); err != nil {
+		log.Fatalf("Failed to update unfinished imports: %v", err)
+	} else if n > 0 {
+		log.Printf("Marked %d unfinished imports as failed", n)
+	}
+
+	// ADMIN_USERS に含まれる名前の登録済みのユーザーは管理者にする（カンマ区切り）
+	// 名前を先に取られても管理者にならないよう、ユーザー登録では権限を付けない
+	for _, name := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if u := userStore.FindByName(name); u != nil {
+			if err := userStore.SetRole(u.Id, repo.RoleAdmin); err != nil {
+				log.Fatalf("Failed to promote %s to admin: %v", name, err)
+			}
+		}
+	}
+
+	concurrency, err := strconv.Atoi(os.Getenv("IMPORT_CONCURRENCY"))
+	if err != nil || concurrency <= 0 {
+		concurrency = 2
+	}
+	if v := os.Getenv("UPLOAD_MAX_BYTES"); v != "" {
+		maxBytes, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || maxBytes <= 0 {
+			log.Fatalf("Invalid UPLOAD_MAX_BYTES: %q", v)
+		}
+		importer.MaxUploadBytes = maxBytes
+	}
+	if v := os.Getenv("IMPORT_WORKERS"); v != "" {
+		workers, err := strconv.Atoi(v)
+		if err != nil || workers <= 0 {
+			log.Fatalf("Invalid IMPORT_WORKERS: %q", v)
+		}
+		importer.DefaultWorkers = workers
+	}
+	runner := importer.NewRunner(stationStore, importStore, concurrency)
+
+	// プレビューしたまま放置されたアップロードと、途中で止まった分割アップロードを定期的に削除する
+	go func() {
+		for {
+			if n, err := importer.CleanStaged(24 * time.Hour); err != nil {
+				log.Printf("Staged Upload Clean Error: %v", err)
+			} else if n > 0 {
+				log.Printf("Removed %d stale staged uploads", n)
+			}
+			if n, err := importer.CleanResumable(24 * time.Hour); err != nil {
+				log.Printf("Resumable Upload Clean Error: %v", err)
+			} else if n > 0 {
+				log.Printf("Removed %d stale partial uploads", n)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent) // 204で空レスポンスを返す
 	})
-	mux.HandleFunc("/", home.Home)
-	mux.HandleFunc("/sign-up", signup.SignUp)
-	mux.HandleFunc("/sign-in", signin.SignIn)
+	mux.HandleFunc("/", home.Home(profileStore))
+	mux.HandleFunc("/sign-up", signup.SignUp(userStore))
+	mux.HandleFunc("/sign-in", signin.SignIn(userStore))
 	mux.HandleFunc("/sign-out", signout.SignOut)
-	mux.HandleFunc("/csv", csv.Csv)
+	mux.HandleFunc("/profile", profile.Profile(userStore))
+	mux.HandleFunc("/settings", settings.Settings(userStore))
+	mux.HandleFunc("/csv", csv.Csv(stationStore, importStore, profileStore, runner))
+	mux.HandleFunc("/measurements", csv.Ingest(importStore, profileStore, runner))
+	mux.HandleFunc("/uploads", csv.Uploads(profileStore))
+	mux.HandleFunc("/uploads/{id}", csv.Upload(importStore, profileStore, runner))
+	mux.HandleFunc("/staged/{id}/confirm", csv.Confirm(importStore, runner))
+	mux.HandleFunc("/staged/{id}/discard", csv.Discard())
+	mux.HandleFunc("/imports", imports.History(importStore))
+	mux.HandleFunc("/imports/{id}/rollback", imports.Rollback(importStore, stationStore))
+	mux.HandleFunc("/imports/{id}", imports.Import(importStore))
+	mux.HandleFunc("/imports/{id}/cancel", imports.Cancel(importStore, runner))
+	mux.HandleFunc("/imports/{id}/rejected", imports.Rejected(importStore))
+	mux.HandleFunc("/batches/{id}", imports.Batch(importStore))
+	mux.HandleFunc("/import-profiles", importprofiles.List(profileStore))
+	mux.HandleFunc("/import-profiles/{id}", importprofiles.Edit(profileStore))
+	mux.HandleFunc("/import-profiles/{id}/delete", importprofiles.Delete(profileStore))
+	mux.HandleFunc("/stations", stations.List(stationStore))
+	mux.HandleFunc("/stations/stats", stations.Stats(stationStore))
+	mux.HandleFunc("/users", users.List(userStore))
+	mux.HandleFunc("/users/export", users.Export(userStore))
+	mux.HandleFunc("/users/import", users.Import(userStore))
+	mux.HandleFunc("/users/{id}/restore", users.Restore(userStore))
 
 	log.Println("Server starting on :8080...")
-	if err := http.ListenAndServe(":8080", csrf.Middleware(mux)); err != nil {
+	if err := http.ListenAndServe(":8080", csrf.Middleware(signin.Middleware(userStore, mux))); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// STORE=memory の場合は PostgreSQL に接続せずメモリ上に保存する
+func stores() (repo.UserStore, repo.WeatherStationStore, repo.ImportStore, repo.ImportProfileStore) {
+	if os.Getenv("STORE") == "memory" {
+		log.Println("Using in-memory store")
+		return repo.NewMemoryUserStore(), repo.NewMemoryWeatherStationStore(), repo.NewMemoryImportStore(), repo.NewMemoryImportProfileStore()
+	}
+	db := database.DB()
+	return repo.NewUserRepository(db), repo.NewWeatherStationRepository(db), repo.NewImportRepository(db), repo.NewImportProfileRepository(db)
+}
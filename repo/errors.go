@@ -0,0 +1,36 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrNotFound  = errors.New("repo: not found")
+	ErrDuplicate = errors.New("repo: duplicate")
+	ErrConflict  = errors.New("repo: conflict")
+)
+
+// PostgreSQL のエラーを呼び出し側が errors.Is で判定できるエラーに変換する
+// 元のエラーも errors.As で取り出せるように残しておく
+func translate(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// https://www.postgresql.org/docs/current/errcodes-appendix.html
+		switch pqErr.Code {
+		case "23505": // unique_violation
+			return fmt.Errorf("%w: %w", ErrDuplicate, err)
+		case "23503", "40001", "40P01": // foreign_key_violation, serialization_failure, deadlock_detected
+			return fmt.Errorf("%w: %w", ErrConflict, err)
+		}
+	}
+	return err
+}
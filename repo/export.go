@@ -0,0 +1,177 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var ErrInvalidColumn = errors.New("repo: invalid column")
+
+// 書き出しの条件。絞り込みと並び替えの列は ListOptions と同じで、Cursor は使わない
+// Sort が空の場合は各リポジトリの既定の順、Limit が 0 の場合はすべての行を返す
+type ExportOptions struct {
+	ListOptions
+}
+
+// 書き出しで選べる列
+type Column[T any] struct {
+	Name  string
+	Value func(T) any
+}
+
+// 選択した列
+type Columns[T any] []Column[T]
+
+func (c Columns[T]) Names() []string {
+	names := make([]string, len(c))
+	for i, col := range c {
+		names[i] = col.Name
+	}
+	return names
+}
+
+// v から選択した列の値を順に取り出す
+func (c Columns[T]) Values(v T) []any {
+	values := make([]any, len(c))
+	for i, col := range c {
+		values[i] = col.Value(v)
+	}
+	return values
+}
+
+// テーブルごとの書き出せる列のホワイトリスト
+type columnSet[T any] struct {
+	columns  Columns[T]
+	defaults []string // 列を指定しない場合に書き出す列
+}
+
+// names の列を順に返す。空の場合は既定の列。ホワイトリストにない列や重複は ErrInvalidColumn
+func (c columnSet[T]) resolve(names []string) (Columns[T], error) {
+	if len(names) == 0 {
+		names = c.defaults
+	}
+	selected := make(Columns[T], 0, len(names))
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		j := slices.IndexFunc(c.columns, func(col Column[T]) bool { return col.Name == name })
+		if j < 0 {
+			return nil, fmt.Errorf("%w %q", ErrInvalidColumn, name)
+		}
+		if slices.Contains(names[:i], name) {
+			return nil, fmt.Errorf("%w %q: specified more than once", ErrInvalidColumn, name)
+		}
+		selected = append(selected, c.columns[j])
+	}
+	return selected, nil
+}
+
+var userColumnSet = columnSet[User]{
+	columns: Columns[User]{
+		{"id", func(u User) any { return u.Id }},
+		{"name", func(u User) any { return u.Name }},
+		{"role", func(u User) any { return string(u.Role) }},
+		{"active", func(u User) any { return u.Active }},
+		{"created_at", func(u User) any { return u.CreatedAt }},
+		{"updated_at", func(u User) any { return u.UpdatedAt }},
+	},
+	defaults: []string{"id", "name", "created_at"},
+}
+
+// 既定の列は取り込みの標準の形式 (city;temperature) と同じ
+var weatherStationColumnSet = columnSet[WeatherStation]{
+	columns: Columns[WeatherStation]{
+		{"id", func(s WeatherStation) any { return s.Id }},
+		{"city", func(s WeatherStation) any { return s.City }},
+		{"temperature", func(s WeatherStation) any { return s.Temperature }},
+		{"measured_at", func(s WeatherStation) any { return s.MeasuredAt }},
+		{"uploaded_by", func(s WeatherStation) any { return s.UploadedBy }},
+		{"import_id", func(s WeatherStation) any { return s.ImportId }},
+	},
+	defaults: []string{"city", "temperature"},
+}
+
+// 最小・平均・最大は小数点以下1桁に丸めた値
+var cityStatColumnSet = columnSet[CityStat]{
+	columns: Columns[CityStat]{
+		{"city", func(s CityStat) any { return s.City }},
+		{"min", func(s CityStat) any { return s.Rounded().Min }},
+		{"mean", func(s CityStat) any { return s.Rounded().Mean }},
+		{"max", func(s CityStat) any { return s.Rounded().Max }},
+		{"count", func(s CityStat) any { return s.Count }},
+	},
+	defaults: []string{"city", "min", "mean", "max", "count"},
+}
+
+// users から書き出す列。names が空の場合は id, name, created_at
+func UserExportColumns(names []string) (Columns[User], error) {
+	return userColumnSet.resolve(names)
+}
+
+// weather_stations から書き出す列。names が空の場合は city, temperature
+func WeatherStationExportColumns(names []string) (Columns[WeatherStation], error) {
+	return weatherStationColumnSet.resolve(names)
+}
+
+// 都市別集計から書き出す列。names が空の場合はすべての列
+func CityStatExportColumns(names []string) (Columns[CityStat], error) {
+	return cityStatColumnSet.resolve(names)
+}
+
+// 画面で選択肢として表示する列の名前
+var (
+	UserColumnNames           = userColumnSet.columns.Names()
+	WeatherStationColumnNames = weatherStationColumnSet.columns.Names()
+	CityStatColumnNames       = cityStatColumnSet.columns.Names()
+)
+
+// 並び替えの列がホワイトリストにあるか。Sort が空の場合は主キーの順なので常に正しい
+func (s sortable[T]) checkSort(o ListOptions) error {
+	if _, ok := s.columns[o.Sort]; o.Sort != "" && !ok {
+		return ErrInvalidSort
+	}
+	return nil
+}
+
+// 書き出しの ORDER BY と LIMIT の句。Sort が空の場合は主キーの順
+func (s sortable[T]) exportTail(o ListOptions) (string, error) {
+	dir := "ASC"
+	if o.Desc {
+		dir = "DESC"
+	}
+	if err := s.checkSort(o); err != nil {
+		return "", err
+	}
+	tail := fmt.Sprintf(" ORDER BY %s %s", s.idColumn, dir)
+	if col, ok := s.columns[o.Sort]; ok {
+		tail = fmt.Sprintf(" ORDER BY %s %s, %s %s", col.expr, dir, s.idColumn, dir)
+	}
+	if o.Limit > 0 {
+		tail += fmt.Sprintf(" LIMIT %d", o.Limit)
+	}
+	return tail, nil
+}
+
+// メモリ上の行に exportTail と同じ並び順と件数を適用する。items は並び替えられる
+// 並び替えの列は checkSort で確認してから呼ぶこと
+func (s sortable[T]) exportSlice(items []T, o ListOptions) []T {
+	col, sorted := s.columns[o.Sort]
+	slices.SortFunc(items, func(a, b T) int {
+		c := 0
+		if sorted {
+			c = col.compare(a, col.key(b))
+		}
+		if c == 0 {
+			c = s.compareId(a, s.id(b))
+		}
+		if o.Desc {
+			return -c
+		}
+		return c
+	})
+	if o.Limit > 0 && len(items) > o.Limit {
+		items = items[:o.Limit]
+	}
+	return items
+}
@@ -0,0 +1,138 @@
+package repo
+
+import (
+	"cmp"
+	"iter"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ImportStore のメモリ上の実装
+type MemoryImportStore struct {
+	mu      sync.RWMutex
+	imports map[string]Import
+	errors  map[string][]ImportError
+}
+
+func NewMemoryImportStore() *MemoryImportStore {
+	return &MemoryImportStore{imports: make(map[string]Import), errors: make(map[string][]ImportError)}
+}
+
+func (m *MemoryImportStore) Create(imp *Import) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if imp.Id == "" {
+		imp.Id = NewImportId()
+	}
+	if _, ok := m.imports[imp.Id]; ok {
+		return ErrDuplicate
+	}
+	imp.Status = StatusQueued
+	imp.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	imp.UpdatedAt = imp.CreatedAt
+	m.imports[imp.Id] = *imp
+	return nil
+}
+
+func (m *MemoryImportStore) FindById(id string) (*Import, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	imp, ok := m.imports[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &imp, nil
+}
+
+func (m *MemoryImportStore) List(userId string, opts ListOptions) (Page[Import], error) {
+	m.mu.RLock()
+	var imports []Import
+	for _, imp := range m.imports {
+		if userId == "" || imp.UserId != userId {
+			continue
+		}
+		if opts.Status != "" && imp.Status != opts.Status {
+			continue
+		}
+		imports = append(imports, imp)
+	}
+	m.mu.RUnlock()
+	return importSortable.paginateSlice(imports, opts)
+}
+
+func (m *MemoryImportStore) FindByBatch(batchId string) ([]Import, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var imports []Import
+	for _, imp := range m.imports {
+		if batchId != "" && imp.BatchId == batchId {
+			imports = append(imports, imp)
+		}
+	}
+	slices.SortFunc(imports, func(a, b Import) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.Filename, b.Filename))
+	})
+	return imports, nil
+}
+
+func (m *MemoryImportStore) Update(imp *Import) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.imports[imp.Id]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Status = imp.Status
+	stored.Encoding = imp.Encoding
+	stored.StartedAt = imp.StartedAt
+	stored.FinishedAt = imp.FinishedAt
+	stored.RowsProcessed = imp.RowsProcessed
+	stored.RowsRejected = imp.RowsRejected
+	stored.Result = imp.Result
+	stored.Error = imp.Error
+	stored.UpdatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	m.imports[imp.Id] = stored
+	return nil
+}
+
+func (m *MemoryImportStore) FailUnfinished(reason string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, imp := range m.imports {
+		if imp.Status == StatusQueued || imp.Status == StatusRunning {
+			now := time.Now().UTC().Truncate(time.Microsecond)
+			imp.Status = StatusFailed
+			imp.Error = reason
+			imp.FinishedAt = &now
+			m.imports[id] = imp
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryImportStore) AddErrors(id string, errs []ImportError) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.imports[id]; !ok {
+		return ErrConflict
+	}
+	m.errors[id] = append(m.errors[id], errs...)
+	return nil
+}
+
+func (m *MemoryImportStore) Errors(id string) iter.Seq2[ImportError, error] {
+	return func(yield func(ImportError, error) bool) {
+		m.mu.RLock()
+		errs := slices.Clone(m.errors[id])
+		m.mu.RUnlock()
+		slices.SortStableFunc(errs, func(a, b ImportError) int { return cmp.Compare(a.Line, b.Line) })
+		for _, e := range errs {
+			if !yield(e, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package repo
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ImportProfileStore のメモリ上の実装
+type MemoryImportProfileStore struct {
+	mu       sync.RWMutex
+	nextId   int64
+	profiles map[int64]ImportProfile
+}
+
+func NewMemoryImportProfileStore() *MemoryImportProfileStore {
+	return &MemoryImportProfileStore{profiles: make(map[int64]ImportProfile)}
+}
+
+func (m *MemoryImportProfileStore) List(userId string) ([]ImportProfile, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var profiles []ImportProfile
+	for _, p := range m.profiles {
+		if p.UserId == userId {
+			profiles = append(profiles, p)
+		}
+	}
+	slices.SortFunc(profiles, func(a, b ImportProfile) int {
+		return cmp.Or(cmp.Compare(a.Name, b.Name), cmp.Compare(a.Id, b.Id))
+	})
+	return profiles, nil
+}
+
+func (m *MemoryImportProfileStore) FindById(userId string, id int64) (*ImportProfile, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.profiles[id]
+	if !ok || p.UserId != userId {
+		return nil, ErrNotFound
+	}
+	return &p, nil
+}
+
+func (m *MemoryImportProfileStore) Create(p *ImportProfile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.nameTaken(p.UserId, p.Name, 0) {
+		return ErrDuplicate
+	}
+	m.nextId++
+	p.Id = m.nextId
+	p.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	p.UpdatedAt = p.CreatedAt
+	m.profiles[p.Id] = *p
+	return nil
+}
+
+func (m *MemoryImportProfileStore) Update(p *ImportProfile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.profiles[p.Id]
+	if !ok || stored.UserId != p.UserId {
+		return ErrNotFound
+	}
+	if m.nameTaken(p.UserId, p.Name, p.Id) {
+		return ErrDuplicate
+	}
+	p.CreatedAt = stored.CreatedAt
+	p.UpdatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	m.profiles[p.Id] = *p
+	return nil
+}
+
+func (m *MemoryImportProfileStore) Delete(userId string, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.profiles[id]
+	if !ok || p.UserId != userId {
+		return ErrNotFound
+	}
+	delete(m.profiles, id)
+	return nil
+}
+
+// ロックを取った状態で呼ぶこと
+func (m *MemoryImportProfileStore) nameTaken(userId, name string, except int64) bool {
+	for _, p := range m.profiles {
+		if p.UserId == userId && p.Name == name && p.Id != except {
+			return true
+		}
+	}
+	return false
+}
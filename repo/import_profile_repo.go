@@ -0,0 +1,181 @@
+package repo
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 引用符の扱い
+type QuoteMode string
+
+const (
+	QuoteLazy   QuoteMode = "lazy"   // 値の途中の " も許容する
+	QuoteStrict QuoteMode = "strict" // RFC 4180 に従わない引用符はエラーにする
+	QuoteNone   QuoteMode = "none"   // " を通常の文字として扱う
+)
+
+// 取り込むファイルの形式。ユーザーごとに名前を付けて保存する
+type ImportProfile struct {
+	Id                int64
+	UserId            string
+	Name              string
+	Delimiter         string // 区切り文字。タブは "\t"
+	Quote             QuoteMode
+	Comment           string // この文字で始まる行を読み飛ばす。空の場合はコメントなし
+	Header            bool   // 先頭行（SkipRows の後）が見出し行か
+	CityColumn        string // 見出しの名前または1始まりの列番号
+	TemperatureColumn string
+	MeasuredAtColumn  string // 空の場合は観測日時の列なし
+	DecimalSeparator  string // "." または ","
+	SkipRows          int    // 先頭で読み飛ばす行数
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+// プロファイルを選ばなかった場合の形式。weather_stations.csv と同じ city;temperature[;measured_at]
+func DefaultImportProfile() ImportProfile {
+	return ImportProfile{
+		Name:              "標準 (city;temperature;measured_at)",
+		Delimiter:         ";",
+		Quote:             QuoteLazy,
+		Comment:           "#",
+		CityColumn:        "1",
+		TemperatureColumn: "2",
+		MeasuredAtColumn:  "3",
+		DecimalSeparator:  ".",
+	}
+}
+
+func (p ImportProfile) DelimiterRune() rune {
+	r, _ := utf8.DecodeRuneInString(p.Delimiter)
+	return r
+}
+
+// コメントなしの場合は 0
+func (p ImportProfile) CommentRune() rune {
+	if p.Comment == "" {
+		return 0
+	}
+	r, _ := utf8.DecodeRuneInString(p.Comment)
+	return r
+}
+
+// 保存前に形式の組み合わせを検証する。問題がある項目ごとのメッセージを返す
+func (p ImportProfile) Validate() map[string][]string {
+	errMsg := make(map[string][]string)
+	if strings.TrimSpace(p.Name) == "" {
+		errMsg["name"] = append(errMsg["name"], "名前は必須です")
+	}
+	if utf8.RuneCountInString(p.Delimiter) != 1 || p.Delimiter == "\r" || p.Delimiter == "\n" || p.Delimiter == `"` {
+		errMsg["delimiter"] = append(errMsg["delimiter"], "区切り文字は改行と \" 以外の1文字で入力してください")
+	}
+	if p.Quote != QuoteLazy && p.Quote != QuoteStrict && p.Quote != QuoteNone {
+		errMsg["quote"] = append(errMsg["quote"], "引用符の扱いが不正です")
+	}
+	if utf8.RuneCountInString(p.Comment) > 1 || (p.Comment != "" && p.Comment == p.Delimiter) {
+		errMsg["comment"] = append(errMsg["comment"], "コメント文字は区切り文字以外の1文字で入力してください")
+	}
+	if strings.TrimSpace(p.CityColumn) == "" {
+		errMsg["cityColumn"] = append(errMsg["cityColumn"], "都市の列は必須です")
+	}
+	if strings.TrimSpace(p.TemperatureColumn) == "" {
+		errMsg["temperatureColumn"] = append(errMsg["temperatureColumn"], "気温の列は必須です")
+	}
+	for key, column := range map[string]string{"cityColumn": p.CityColumn, "temperatureColumn": p.TemperatureColumn, "measuredAtColumn": p.MeasuredAtColumn} {
+		if _, err := strconv.Atoi(column); err != nil && column != "" && !p.Header {
+			errMsg[key] = append(errMsg[key], "見出し行がない場合は列番号で指定してください")
+		}
+	}
+	if p.DecimalSeparator != "." && p.DecimalSeparator != "," {
+		errMsg["decimalSeparator"] = append(errMsg["decimalSeparator"], "小数点は . か , を選んでください")
+	} else if p.DecimalSeparator == p.Delimiter {
+		errMsg["decimalSeparator"] = append(errMsg["decimalSeparator"], "小数点と区切り文字は別の文字にしてください")
+	}
+	if p.SkipRows < 0 {
+		errMsg["skipRows"] = append(errMsg["skipRows"], "読み飛ばす行数は0以上で入力してください")
+	}
+	return errMsg
+}
+
+type ImportProfileRepository struct {
+	db *sql.DB
+}
+
+func NewImportProfileRepository(db *sql.DB) *ImportProfileRepository {
+	return &ImportProfileRepository{db: db}
+}
+
+const importProfileColumns = `id, user_id, name, delimiter, quote, comment, header, city_column, temperature_column,
+	measured_at_column, decimal_separator, skip_rows, created_at, updated_at`
+
+func scanImportProfile(row scanner) (ImportProfile, error) {
+	var p ImportProfile
+	err := row.Scan(&p.Id, &p.UserId, &p.Name, &p.Delimiter, &p.Quote, &p.Comment, &p.Header, &p.CityColumn, &p.TemperatureColumn,
+		&p.MeasuredAtColumn, &p.DecimalSeparator, &p.SkipRows, &p.CreatedAt, &p.UpdatedAt)
+	return p, err
+}
+
+// ユーザーのプロファイルを名前順に返す
+func (i *ImportProfileRepository) List(userId string) ([]ImportProfile, error) {
+	rows, err := i.db.Query("SELECT "+importProfileColumns+" FROM import_profiles WHERE user_id = $1 ORDER BY name, id", userId)
+	if err != nil {
+		return nil, translate(err)
+	}
+	defer rows.Close()
+
+	var profiles []ImportProfile
+	for rows.Next() {
+		p, err := scanImportProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, rows.Err()
+}
+
+// 他のユーザーのプロファイルは見つからない扱いにする
+func (i *ImportProfileRepository) FindById(userId string, id int64) (*ImportProfile, error) {
+	p, err := scanImportProfile(i.db.QueryRow("SELECT "+importProfileColumns+" FROM import_profiles WHERE id = $1 AND user_id = $2", id, userId))
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &p, nil
+}
+
+// 同じユーザーで同じ名前のプロファイルがある場合は ErrDuplicate を返す
+func (i *ImportProfileRepository) Create(p *ImportProfile) error {
+	err := i.db.QueryRow(`INSERT INTO import_profiles (user_id, name, delimiter, quote, comment, header, city_column, temperature_column,
+		measured_at_column, decimal_separator, skip_rows) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, created_at, updated_at`,
+		p.UserId, p.Name, p.Delimiter, p.Quote, p.Comment, p.Header, p.CityColumn, p.TemperatureColumn,
+		p.MeasuredAtColumn, p.DecimalSeparator, p.SkipRows).Scan(&p.Id, &p.CreatedAt, &p.UpdatedAt)
+	return translate(err)
+}
+
+func (i *ImportProfileRepository) Update(p *ImportProfile) error {
+	err := i.db.QueryRow(`UPDATE import_profiles SET name = $3, delimiter = $4, quote = $5, comment = $6, header = $7,
+		city_column = $8, temperature_column = $9, measured_at_column = $10, decimal_separator = $11, skip_rows = $12,
+		updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND user_id = $2 RETURNING updated_at`,
+		p.Id, p.UserId, p.Name, p.Delimiter, p.Quote, p.Comment, p.Header, p.CityColumn, p.TemperatureColumn,
+		p.MeasuredAtColumn, p.DecimalSeparator, p.SkipRows).Scan(&p.UpdatedAt)
+	return translate(err)
+}
+
+func (i *ImportProfileRepository) Delete(userId string, id int64) error {
+	res, err := i.db.Exec("DELETE FROM import_profiles WHERE id = $1 AND user_id = $2", id, userId)
+	if err != nil {
+		return translate(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
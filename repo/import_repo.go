@@ -0,0 +1,275 @@
+package repo
+
+import (
+	"database/sql"
+	"iter"
+	"strings"
+	"time"
+)
+
+type ImportStatus string
+
+const (
+	StatusQueued     ImportStatus = "queued"
+	StatusRunning    ImportStatus = "running"
+	StatusSucceeded  ImportStatus = "succeeded"
+	StatusFailed     ImportStatus = "failed"
+	StatusCancelled  ImportStatus = "cancelled"
+	StatusRolledBack ImportStatus = "rolled_back" // 完了後に追加した行を削除した
+)
+
+// 終了した状態か
+func (s ImportStatus) Done() bool {
+	return s == StatusSucceeded || s == StatusFailed || s == StatusCancelled || s == StatusRolledBack
+}
+
+func (s ImportStatus) Label() string {
+	switch s {
+	case StatusQueued:
+		return "待機中"
+	case StatusRunning:
+		return "取り込み中"
+	case StatusSucceeded:
+		return "完了"
+	case StatusFailed:
+		return "失敗"
+	case StatusCancelled:
+		return "キャンセル"
+	case StatusRolledBack:
+		return "取り消し済み"
+	}
+	return string(s)
+}
+
+// バックグラウンドで実行する取り込みジョブ
+type Import struct {
+	Id            string
+	UserId        string
+	BatchId       string // zip でまとめてアップロードしたファイルに共通の ID。1ファイルの場合は空
+	Filename      string
+	Checksum      string // アップロードされたファイルの SHA-256。zip の中のファイルは zip 全体の値
+	Mode          ImportMode
+	Encoding      string // アップロード時に指定した文字コード。取り込み開始後は判定結果
+	Unit          string // アップロードされた気温の単位。保存した値は摂氏
+	Status        ImportStatus
+	RowsProcessed int64 // 読み込んだ行数（不正な行を含む）
+	RowsRejected  int64
+	Result        ImportResult
+	Error         string // 失敗した場合の理由
+	StartedAt     *time.Time
+	FinishedAt    *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// 取り込みにかかった時間。終わっていない場合は 0
+func (i Import) Duration() time.Duration {
+	if i.StartedAt == nil || i.FinishedAt == nil {
+		return 0
+	}
+	return i.FinishedAt.Sub(*i.StartedAt)
+}
+
+// 取り込めなかった行
+type ImportError struct {
+	Line   int
+	Raw    string
+	Reason string
+}
+
+type ImportRepository struct {
+	db *sql.DB
+}
+
+func NewImportRepository(db *sql.DB) *ImportRepository {
+	return &ImportRepository{db: db}
+}
+
+const importColumns = `id, COALESCE(user_id::text, ''), COALESCE(batch_id::text, ''), filename, checksum, mode, encoding, unit, status, rows_processed, rows_rejected,
+	inserted, updated, skipped, deleted, error, started_at, finished_at, created_at, updated_at`
+
+func scanImport(row scanner) (Import, error) {
+	var i Import
+	err := row.Scan(&i.Id, &i.UserId, &i.BatchId, &i.Filename, &i.Checksum, &i.Mode, &i.Encoding, &i.Unit, &i.Status, &i.RowsProcessed, &i.RowsRejected,
+		&i.Result.Inserted, &i.Result.Updated, &i.Result.Skipped, &i.Result.Deleted, &i.Error, &i.StartedAt, &i.FinishedAt, &i.CreatedAt, &i.UpdatedAt)
+	return i, err
+}
+
+// 待機中のジョブを登録する。Id が空の場合は採番する
+func (i *ImportRepository) Create(imp *Import) error {
+	if imp.Id == "" {
+		imp.Id = NewImportId()
+	}
+	imp.Status = StatusQueued
+	err := i.db.QueryRow("INSERT INTO imports (id, user_id, batch_id, filename, checksum, mode, encoding, unit, status) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING created_at, updated_at",
+		imp.Id, nullable(imp.UserId), nullable(imp.BatchId), imp.Filename, imp.Checksum, imp.Mode, imp.Encoding, imp.Unit, imp.Status).Scan(&imp.CreatedAt, &imp.UpdatedAt)
+	return translate(err)
+}
+
+func (i *ImportRepository) FindById(id string) (*Import, error) {
+	if !isUuid(id) {
+		return nil, ErrNotFound
+	}
+	imp, err := scanImport(i.db.QueryRow("SELECT "+importColumns+" FROM imports WHERE id = $1", id))
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &imp, nil
+}
+
+// 同じ zip でアップロードしたジョブを登録順に返す
+func (i *ImportRepository) FindByBatch(batchId string) ([]Import, error) {
+	if !isUuid(batchId) {
+		return nil, nil
+	}
+	rows, err := i.db.Query("SELECT "+importColumns+" FROM imports WHERE batch_id = $1 ORDER BY created_at, filename", batchId)
+	if err != nil {
+		return nil, translate(err)
+	}
+	defer rows.Close()
+
+	var imports []Import
+	for rows.Next() {
+		imp, err := scanImport(rows)
+		if err != nil {
+			return nil, err
+		}
+		imports = append(imports, imp)
+	}
+	return imports, rows.Err()
+}
+
+var importSortable = sortable[Import]{
+	columns: map[string]sortColumn[Import]{
+		"created_at": {
+			expr: "created_at",
+			cast: "timestamp",
+			key:  func(i Import) string { return i.CreatedAt.Format(time.RFC3339Nano) },
+			compare: func(i Import, v string) int {
+				t, _ := time.Parse(time.RFC3339Nano, v)
+				return i.CreatedAt.Compare(t)
+			},
+		},
+		"filename": {
+			expr:    `filename COLLATE "C"`,
+			cast:    "text",
+			key:     func(i Import) string { return i.Filename },
+			compare: func(i Import, v string) int { return strings.Compare(i.Filename, v) },
+		},
+	},
+	defaultSort: "created_at",
+	idColumn:    "id",
+	id:          func(i Import) string { return i.Id },
+	compareId:   func(i Import, v string) int { return strings.Compare(i.Id, v) },
+}
+
+// ユーザーの取り込み履歴をキーセットページネーションで取得する
+func (i *ImportRepository) List(userId string, opts ListOptions) (Page[Import], error) {
+	if !isUuid(userId) {
+		return Page[Import]{}, nil
+	}
+	w := &where{}
+	w.add("user_id = $%d", userId)
+	if opts.Status != "" {
+		w.add("status = $%d", opts.Status)
+	}
+	tail, err := importSortable.paginate(w, opts)
+	if err != nil {
+		return Page[Import]{}, err
+	}
+
+	rows, err := i.db.Query("SELECT "+importColumns+" FROM imports"+w.String()+tail, w.args...)
+	if err != nil {
+		return Page[Import]{}, translate(err)
+	}
+	defer rows.Close()
+
+	var imports []Import
+	for rows.Next() {
+		imp, err := scanImport(rows)
+		if err != nil {
+			return Page[Import]{}, err
+		}
+		imports = append(imports, imp)
+	}
+	if err := rows.Err(); err != nil {
+		return Page[Import]{}, err
+	}
+	return importSortable.page(imports, opts), nil
+}
+
+// 状態と件数を保存する
+func (i *ImportRepository) Update(imp *Import) error {
+	res, err := i.db.Exec(`UPDATE imports SET status = $2, encoding = $3, rows_processed = $4, rows_rejected = $5,
+		inserted = $6, updated = $7, skipped = $8, deleted = $9, error = $10, started_at = $11, finished_at = $12,
+		updated_at = CURRENT_TIMESTAMP WHERE id = $1`,
+		imp.Id, imp.Status, imp.Encoding, imp.RowsProcessed, imp.RowsRejected,
+		imp.Result.Inserted, imp.Result.Updated, imp.Result.Skipped, imp.Result.Deleted, imp.Error, imp.StartedAt, imp.FinishedAt)
+	if err != nil {
+		return translate(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// 起動時に前回のプロセスで終わらなかったジョブを失敗にする
+func (i *ImportRepository) FailUnfinished(reason string) (int64, error) {
+	res, err := i.db.Exec("UPDATE imports SET status = $1, error = $2, finished_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE status IN ($3, $4)",
+		StatusFailed, reason, StatusQueued, StatusRunning)
+	if err != nil {
+		return 0, translate(err)
+	}
+	return res.RowsAffected()
+}
+
+func (i *ImportRepository) AddErrors(id string, errs []ImportError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	tx, err := i.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare("INSERT INTO import_errors (import_id, line, raw, reason) VALUES ($1, $2, $3, $4)")
+	if err != nil {
+		return translate(err)
+	}
+	defer stmt.Close()
+	for _, e := range errs {
+		if _, err := stmt.Exec(id, e.Line, e.Raw, e.Reason); err != nil {
+			return translate(err)
+		}
+	}
+	return translate(tx.Commit())
+}
+
+// 取り込めなかった行を行番号順に返す
+func (i *ImportRepository) Errors(id string) iter.Seq2[ImportError, error] {
+	return func(yield func(ImportError, error) bool) {
+		rows, err := i.db.Query("SELECT line, raw, reason FROM import_errors WHERE import_id = $1 ORDER BY line, id", id)
+		if err != nil {
+			yield(ImportError{}, err)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var e ImportError
+			if err := rows.Scan(&e.Line, &e.Raw, &e.Reason); err != nil {
+				yield(ImportError{}, err)
+				return
+			}
+			if !yield(e, nil) {
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			yield(ImportError{}, err)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package repo
+
+import (
+	"slices"
+)
+
+// メモリ上の行に paginate と同じ並び順・カーソル・件数を適用する。items は並び替えられる
+func (s sortable[T]) paginateSlice(items []T, o ListOptions) (Page[T], error) {
+	name, col, err := s.sort(o)
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	compare := func(a T, value, id string) int {
+		c := col.compare(a, value)
+		if c == 0 {
+			c = s.compareId(a, id)
+		}
+		if o.Desc {
+			return -c
+		}
+		return c
+	}
+	slices.SortFunc(items, func(a, b T) int {
+		return compare(a, col.key(b), s.id(b))
+	})
+
+	if o.Cursor != "" {
+		c, err := decodeCursor(o.Cursor)
+		if err != nil {
+			return Page[T]{}, err
+		}
+		if c.Sort != name || c.Desc != o.Desc {
+			return Page[T]{}, ErrInvalidCursor
+		}
+		i, _ := slices.BinarySearchFunc(items, c, func(a T, c cursor) int {
+			// カーソルと同じ行は前ページに含まれているので後ろ側に寄せる
+			if compare(a, c.Value, c.Id) <= 0 {
+				return -1
+			}
+			return 1
+		})
+		items = items[i:]
+	}
+
+	if len(items) > o.limit()+1 {
+		items = items[:o.limit()+1]
+	}
+	return s.page(items, o), nil
+}
@@ -0,0 +1,182 @@
+package repo
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+var (
+	ErrInvalidSort   = errors.New("repo: invalid sort column")
+	ErrInvalidCursor = errors.New("repo: invalid cursor")
+	ErrInvalidImport = errors.New("repo: invalid import id")
+)
+
+// 一覧取得の条件。絞り込み条件は対象のテーブルに存在するものだけが使われる
+type ListOptions struct {
+	Sort   string // 並び替える列。各リポジトリのホワイトリストにあるもののみ
+	Desc   bool
+	Limit  int
+	Cursor string // 前ページの Page.Next をそのまま渡す
+
+	// users
+	NameContains string
+	CreatedFrom  time.Time // この日時以降
+	CreatedTo    time.Time // この日時より前
+	Deleted      bool      // 論理削除したユーザーだけを対象にする
+
+	// weather_stations
+	City           string
+	MinTemperature *float64
+	MaxTemperature *float64
+	ImportId       string
+	MeasuredFrom   time.Time // この日時以降
+	MeasuredTo     time.Time // この日時より前
+
+	// imports
+	Status ImportStatus
+}
+
+// キーセットページネーションの結果
+type Page[T any] struct {
+	Items []T
+	Next  string // 次ページのカーソル。最終ページでは空
+}
+
+func (o ListOptions) limit() int {
+	if o.Limit <= 0 {
+		return DefaultLimit
+	}
+	return min(o.Limit, MaxLimit)
+}
+
+// 並び替え可能な列の定義
+type sortColumn[T any] struct {
+	expr    string              // ORDER BY に使う列
+	cast    string              // カーソルの値を比較するときの型
+	key     func(T) string      // 行からカーソルに入れる値を取り出す
+	compare func(T, string) int // 行の値とカーソルの値を比較する（メモリ上の実装用）
+}
+
+// テーブルごとの並び替えのホワイトリスト
+type sortable[T any] struct {
+	columns     map[string]sortColumn[T]
+	defaultSort string
+	idColumn    string              // 同じ値の行を区別するための主キー
+	id          func(T) string      // 行から主キーを取り出す
+	compareId   func(T, string) int // 行の主キーとカーソルの主キーを比較する（メモリ上の実装用）
+}
+
+// カーソルの中身。並び順が変わったカーソルは受け付けない
+type cursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	Id    string `json:"i"`
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// *sql.DB と *sql.Tx のどちらでも実行できるようにする
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// WHERE 句をプレースホルダの番号を振りながら組み立てる
+type where struct {
+	conds []string
+	args  []any
+}
+
+// cond の %d には追加した引数のプレースホルダ番号が入る
+func (w *where) add(cond string, arg any) {
+	w.args = append(w.args, arg)
+	w.conds = append(w.conds, fmt.Sprintf(cond, len(w.args)))
+}
+
+func (w *where) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.conds, " AND ")
+}
+
+func (s sortable[T]) sort(o ListOptions) (string, sortColumn[T], error) {
+	name := o.Sort
+	if name == "" {
+		name = s.defaultSort
+	}
+	col, ok := s.columns[name]
+	if !ok {
+		return "", col, ErrInvalidSort
+	}
+	return name, col, nil
+}
+
+// 並び順・カーソル・件数を SQL に反映する。戻り値は ORDER BY 以降の句
+func (s sortable[T]) paginate(w *where, o ListOptions) (string, error) {
+	name, col, err := s.sort(o)
+	if err != nil {
+		return "", err
+	}
+
+	dir, op := "ASC", ">"
+	if o.Desc {
+		dir, op = "DESC", "<"
+	}
+
+	if o.Cursor != "" {
+		c, err := decodeCursor(o.Cursor)
+		if err != nil {
+			return "", err
+		}
+		if c.Sort != name || c.Desc != o.Desc {
+			return "", ErrInvalidCursor
+		}
+		w.args = append(w.args, c.Value, c.Id)
+		w.conds = append(w.conds, fmt.Sprintf("(%s, %s) %s ($%d::%s, $%d)", col.expr, s.idColumn, op, len(w.args)-1, col.cast, len(w.args)))
+	}
+
+	// 次ページの有無を判定するため1件多く取得する
+	return fmt.Sprintf(" ORDER BY %s %s, %s %s LIMIT %d", col.expr, dir, s.idColumn, dir, o.limit()+1), nil
+}
+
+// 取得した行からページを作る。items は paginate の条件で取得したものであること
+func (s sortable[T]) page(items []T, o ListOptions) Page[T] {
+	limit := o.limit()
+	if len(items) <= limit {
+		return Page[T]{Items: items}
+	}
+	name, col, _ := s.sort(o)
+	last := items[limit-1]
+	return Page[T]{
+		Items: items[:limit],
+		Next:  encodeCursor(cursor{Sort: name, Desc: o.Desc, Value: col.key(last), Id: s.id(last)}),
+	}
+}
@@ -0,0 +1,64 @@
+package repo
+
+import "iter"
+
+// ユーザーの保存先。PostgreSQL とメモリ上の実装がある
+type UserStore interface {
+	Create(name, password string) (*User, error)
+	CreateWithRole(name, password string, role Role) (*User, error)
+	Auth(name, password string) bool
+	FindByName(name string) *User
+	FindById(id string) (*User, error)
+	List(opts ListOptions) (Page[User], error)
+	Export(opts ExportOptions) (iter.Seq2[User, error], error)
+	Rename(id, name string) error
+	ChangePassword(id, current, password string) error
+	SetRole(id string, role Role) error
+	Deactivate(id string) error
+	SoftDelete(id string) error
+	Restore(id string) error
+	HardDelete(id string) error
+}
+
+// 観測値の保存先。PostgreSQL とメモリ上の実装がある
+type WeatherStationStore interface {
+	BulkInsert(values []WeatherStation) error
+	Import(mode ImportMode, values []WeatherStation) (ImportResult, error)
+	Begin() (WeatherStationTx, error)
+	DeleteByImport(importId string) (int64, error)
+	Stats(filter StatsFilter) ([]CityStat, error)
+	List(opts ListOptions) (Page[WeatherStation], error)
+	Export(opts ExportOptions) (iter.Seq2[WeatherStation, error], error)
+}
+
+// 取り込みジョブの保存先。PostgreSQL とメモリ上の実装がある
+type ImportStore interface {
+	Create(imp *Import) error
+	FindById(id string) (*Import, error)
+	FindByBatch(batchId string) ([]Import, error)
+	List(userId string, opts ListOptions) (Page[Import], error)
+	Update(imp *Import) error
+	FailUnfinished(reason string) (int64, error)
+	AddErrors(id string, errs []ImportError) error
+	Errors(id string) iter.Seq2[ImportError, error]
+}
+
+// 取り込みプロファイルの保存先。PostgreSQL とメモリ上の実装がある
+type ImportProfileStore interface {
+	List(userId string) ([]ImportProfile, error)
+	FindById(userId string, id int64) (*ImportProfile, error)
+	Create(p *ImportProfile) error
+	Update(p *ImportProfile) error
+	Delete(userId string, id int64) error
+}
+
+var (
+	_ UserStore           = (*UserRepository)(nil)
+	_ UserStore           = (*MemoryUserStore)(nil)
+	_ WeatherStationStore = (*WeatherStationRepository)(nil)
+	_ WeatherStationStore = (*MemoryWeatherStationStore)(nil)
+	_ ImportStore         = (*MemoryImportStore)(nil)
+	_ ImportStore         = (*ImportRepository)(nil)
+	_ ImportProfileStore  = (*ImportProfileRepository)(nil)
+	_ ImportProfileStore  = (*MemoryImportProfileStore)(nil)
+)
@@ -0,0 +1,221 @@
+package repo
+
+import (
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"iter"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UserStore のメモリ上の実装。PostgreSQL と同じくユーザー名は一意でパスワードは bcrypt で保存する
+type MemoryUserStore struct {
+	mu    sync.RWMutex
+	users []User // 登録順
+}
+
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{}
+}
+
+func (m *MemoryUserStore) find(name string) (User, bool) {
+	for _, user := range m.users {
+		if user.Name == name {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+func (m *MemoryUserStore) Create(name, password string) (*User, error) {
+	return m.CreateWithRole(name, password, RoleUser)
+}
+
+func (m *MemoryUserStore) CreateWithRole(name, password string, role Role) (*User, error) {
+	// パスワードはハッシュ化して保存する
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.find(name); ok {
+		return nil, fmt.Errorf("%w: user %q already exists", ErrDuplicate, name)
+	}
+	// PostgreSQL の TIMESTAMP に合わせてマイクロ秒で切り捨てる
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	user := User{Id: newId(), Name: name, password: string(hashedPassword), Role: role, Active: true, CreatedAt: now, UpdatedAt: now}
+	m.users = append(m.users, user)
+	return &User{Id: user.Id, Name: user.Name, Role: user.Role}, nil
+}
+
+func (m *MemoryUserStore) Auth(name, password string) bool {
+	m.mu.RLock()
+	user, ok := m.find(name)
+	m.mu.RUnlock()
+	if !ok || !user.Active || user.DeletedAt != nil {
+		return false
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.password), []byte(password)); err != nil {
+		return false
+	}
+	return true
+}
+
+func (m *MemoryUserStore) FindByName(name string) *User {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	user, ok := m.find(name)
+	if !ok || user.DeletedAt != nil {
+		return nil
+	}
+	return &User{Id: user.Id, Name: user.Name}
+}
+
+func (m *MemoryUserStore) FindById(id string) (*User, error) {
+	for _, user := range m.snapshot(false) {
+		if user.Id == id {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryUserStore) List(opts ListOptions) (Page[User], error) {
+	return userSortable.paginateSlice(m.filter(opts), opts)
+}
+
+func (m *MemoryUserStore) Export(opts ExportOptions) (iter.Seq2[User, error], error) {
+	if opts.Sort == "" {
+		opts.Sort = userSortable.defaultSort
+	}
+	if err := userSortable.checkSort(opts.ListOptions); err != nil {
+		return nil, err
+	}
+	return func(yield func(User, error) bool) {
+		users := userSortable.exportSlice(m.filter(opts.ListOptions), opts.ListOptions)
+		for _, user := range users {
+			if !yield(user, nil) {
+				return
+			}
+		}
+	}, nil
+}
+
+// userWhere と同じ条件に一致するユーザー
+func (m *MemoryUserStore) filter(opts ListOptions) []User {
+	var users []User
+	for _, user := range m.snapshot(opts.Deleted) {
+		if opts.NameContains != "" && !strings.Contains(strings.ToLower(user.Name), strings.ToLower(opts.NameContains)) {
+			continue
+		}
+		if !opts.CreatedFrom.IsZero() && user.CreatedAt.Before(opts.CreatedFrom) {
+			continue
+		}
+		if !opts.CreatedTo.IsZero() && !user.CreatedAt.Before(opts.CreatedTo) {
+			continue
+		}
+		users = append(users, user)
+	}
+	return users
+}
+
+// 論理削除されていない（deleted の場合は論理削除した）ユーザーのコピーをパスワードを除いて返す
+func (m *MemoryUserStore) snapshot(deleted bool) []User {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	users := make([]User, 0, len(m.users))
+	for _, user := range m.users {
+		if (user.DeletedAt != nil) != deleted {
+			continue
+		}
+		user.password = ""
+		users = append(users, user)
+	}
+	return users
+}
+
+// ID が一致するユーザーを更新して updated_at を進める。該当がない場合は ErrNotFound を返す
+func (m *MemoryUserStore) update(id string, deleted bool, fn func(*User) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.users {
+		user := &m.users[i]
+		if user.Id != id || (user.DeletedAt != nil) != deleted {
+			continue
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+		user.UpdatedAt = time.Now().UTC().Truncate(time.Microsecond)
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (m *MemoryUserStore) Rename(id, name string) error {
+	return m.update(id, false, func(user *User) error {
+		if other, ok := m.find(name); ok && other.Id != id {
+			return fmt.Errorf("%w: user %q already exists", ErrDuplicate, name)
+		}
+		user.Name = name
+		return nil
+	})
+}
+
+func (m *MemoryUserStore) ChangePassword(id, current, password string) error {
+	return m.update(id, false, func(user *User) error {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.password), []byte(current)); err != nil {
+			return ErrWrongPassword
+		}
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		user.password = string(hashed)
+		return nil
+	})
+}
+
+func (m *MemoryUserStore) SetRole(id string, role Role) error {
+	return m.update(id, false, func(user *User) error {
+		user.Role = role
+		return nil
+	})
+}
+
+func (m *MemoryUserStore) Deactivate(id string) error {
+	return m.update(id, false, func(user *User) error {
+		user.Active = false
+		return nil
+	})
+}
+
+func (m *MemoryUserStore) SoftDelete(id string) error {
+	return m.update(id, false, func(user *User) error {
+		now := time.Now().UTC().Truncate(time.Microsecond)
+		user.DeletedAt = &now
+		return nil
+	})
+}
+
+func (m *MemoryUserStore) Restore(id string) error {
+	return m.update(id, true, func(user *User) error {
+		user.DeletedAt = nil
+		return nil
+	})
+}
+
+func (m *MemoryUserStore) HardDelete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, user := range m.users {
+		if user.Id == id {
+			m.users = append(m.users[:i], m.users[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
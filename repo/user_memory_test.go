@@ -0,0 +1,257 @@
+package repo
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// names のユーザーを登録順に作る。パスワードはすべて "password1"
+func newUsers(t *testing.T, names ...string) (*MemoryUserStore, []*User) {
+	t.Helper()
+	m := NewMemoryUserStore()
+	users := make([]*User, 0, len(names))
+	for _, name := range names {
+		user, err := m.Create(name, "password1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	return m, users
+}
+
+func TestMemoryUserStoreCreate(t *testing.T) {
+	m, users := newUsers(t, "alice")
+
+	if _, err := m.Create("alice", "password2"); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("duplicate name: err = %v, want ErrDuplicate", err)
+	}
+	admin, err := m.CreateWithRole("bob", "password1", RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users[0].Role != RoleUser || admin.Role != RoleAdmin {
+		t.Errorf("roles = %q, %q, want user, admin", users[0].Role, admin.Role)
+	}
+
+	got, err := m.FindById(users[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "alice" || !got.Active || got.CreatedAt.IsZero() {
+		t.Errorf("FindById = %+v", got)
+	}
+	if got.password != "" {
+		t.Error("FindById returned the password hash")
+	}
+}
+
+func TestMemoryUserStoreAuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(m *MemoryUserStore, id string) error
+		password string
+		want     bool
+	}{
+		{"correct password", nil, "password1", true},
+		{"wrong password", nil, "password2", false},
+		{"deactivated", func(m *MemoryUserStore, id string) error { return m.Deactivate(id) }, "password1", false},
+		{"soft deleted", func(m *MemoryUserStore, id string) error { return m.SoftDelete(id) }, "password1", false},
+		{"restored", func(m *MemoryUserStore, id string) error {
+			if err := m.SoftDelete(id); err != nil {
+				return err
+			}
+			return m.Restore(id)
+		}, "password1", true},
+		{"hard deleted", func(m *MemoryUserStore, id string) error { return m.HardDelete(id) }, "password1", false},
+		{"changed password", func(m *MemoryUserStore, id string) error { return m.ChangePassword(id, "password1", "password9") }, "password9", true},
+		{"old password after change", func(m *MemoryUserStore, id string) error { return m.ChangePassword(id, "password1", "password9") }, "password1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, users := newUsers(t, "alice")
+			if tt.setup != nil {
+				if err := tt.setup(m, users[0].Id); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := m.Auth("alice", tt.password); got != tt.want {
+				t.Errorf("Auth = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryUserStoreUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		update  func(m *MemoryUserStore, alice, bob string) error
+		wantErr error
+	}{
+		{"rename", func(m *MemoryUserStore, alice, _ string) error { return m.Rename(alice, "alice2") }, nil},
+		{"rename to own name", func(m *MemoryUserStore, alice, _ string) error { return m.Rename(alice, "alice") }, nil},
+		{"rename to taken name", func(m *MemoryUserStore, alice, _ string) error { return m.Rename(alice, "bob") }, ErrDuplicate},
+		{"wrong current password", func(m *MemoryUserStore, alice, _ string) error {
+			return m.ChangePassword(alice, "password2", "password3")
+		}, ErrWrongPassword},
+		{"unknown id", func(m *MemoryUserStore, _, _ string) error { return m.SetRole("nobody", RoleAdmin) }, ErrNotFound},
+		{"update after soft delete", func(m *MemoryUserStore, alice, _ string) error {
+			if err := m.SoftDelete(alice); err != nil {
+				return err
+			}
+			return m.SetRole(alice, RoleAdmin)
+		}, ErrNotFound},
+		{"hard delete twice", func(m *MemoryUserStore, alice, _ string) error {
+			if err := m.HardDelete(alice); err != nil {
+				return err
+			}
+			return m.HardDelete(alice)
+		}, ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, users := newUsers(t, "alice", "bob")
+			if err := tt.update(m, users[0].Id, users[1].Id); !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMemoryUserStoreLifecycle(t *testing.T) {
+	m, users := newUsers(t, "alice", "bob")
+	alice := users[0].Id
+
+	if err := m.SetRole(alice, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Deactivate(alice); err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.FindById(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Role != RoleAdmin || got.Active || got.IsAdmin() {
+		t.Errorf("after deactivate: role = %q, active = %v, admin = %v", got.Role, got.Active, got.IsAdmin())
+	}
+	if got.UpdatedAt.Before(got.CreatedAt) {
+		t.Errorf("updated_at %v is before created_at %v", got.UpdatedAt, got.CreatedAt)
+	}
+
+	// 論理削除したユーザーは見つからず、名前も一覧から消えるが、名前は再利用できない
+	if err := m.SoftDelete(alice); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.FindById(alice); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindById after soft delete: err = %v, want ErrNotFound", err)
+	}
+	if m.FindByName("alice") != nil {
+		t.Error("FindByName found a soft deleted user")
+	}
+	if _, err := m.Create("alice", "password1"); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Create after soft delete: err = %v, want ErrDuplicate", err)
+	}
+	page, err := m.List(ListOptions{Deleted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || page.Items[0].Id != alice {
+		t.Errorf("List of deleted users = %+v, want alice only", page.Items)
+	}
+
+	// 復元すると元のパスワードでログインできる
+	if err := m.Restore(alice); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.FindById(alice); err != nil {
+		t.Errorf("FindById after restore: %v", err)
+	}
+	if err := m.SetRole(alice, RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Restore(alice); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Restore of a user that is not deleted: err = %v, want ErrNotFound", err)
+	}
+
+	// 完全に削除すると名前を再利用できる
+	if err := m.HardDelete(alice); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Create("alice", "password1"); err != nil {
+		t.Errorf("Create after hard delete: %v", err)
+	}
+}
+
+func TestMemoryUserStoreList(t *testing.T) {
+	// 名前は COLLATE "C" と同じバイト順なので、大文字で始まる名前が先に並ぶ
+	m, _ := newUsers(t, "carol", "alice", "Bob", "dave", "Alina")
+
+	tests := []struct {
+		name string
+		opts ListOptions
+		want []string
+	}{
+		{"by name", ListOptions{Sort: "name"}, []string{"Alina", "Bob", "alice", "carol", "dave"}},
+		{"by name descending", ListOptions{Sort: "name", Desc: true}, []string{"dave", "carol", "alice", "Bob", "Alina"}},
+		{"name contains, ignoring case", ListOptions{Sort: "name", NameContains: "AL"}, []string{"Alina", "alice"}},
+		{"paged by two", ListOptions{Sort: "name", Limit: 2}, []string{"Alina", "Bob", "alice", "carol", "dave"}},
+		{"paged descending", ListOptions{Sort: "name", Desc: true, Limit: 3}, []string{"dave", "carol", "alice", "Bob", "Alina"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			opts := tt.opts
+			for {
+				page, err := m.List(opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if opts.Limit > 0 && len(page.Items) > opts.Limit {
+					t.Fatalf("page has %d items, limit %d", len(page.Items), opts.Limit)
+				}
+				for _, u := range page.Items {
+					got = append(got, u.Name)
+				}
+				if page.Next == "" {
+					break
+				}
+				opts.Cursor = page.Next
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := m.List(ListOptions{Sort: "password"}); !errors.Is(err, ErrInvalidSort) {
+		t.Errorf("unknown sort: err = %v, want ErrInvalidSort", err)
+	}
+	page, _ := m.List(ListOptions{Sort: "name", Limit: 2})
+	if _, err := m.List(ListOptions{Sort: "created_at", Limit: 2, Cursor: page.Next}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("cursor from another sort: err = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestMemoryUserStoreExport(t *testing.T) {
+	m, users := newUsers(t, "carol", "alice", "bob")
+	if err := m.SoftDelete(users[2].Id); err != nil {
+		t.Fatal(err)
+	}
+
+	seq, err := m.Export(ExportOptions{ListOptions: ListOptions{Sort: "name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for u, err := range seq {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, u.Name)
+	}
+	if want := []string{"alice", "carol"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -3,15 +3,71 @@ package repo
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"golang.org/x/crypto/bcrypt"
+	"iter"
+	"strings"
+	"time"
 )
 
+// ユーザーの権限
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin" // ユーザーの一括登録ができる
+)
+
+var Roles = []Role{RoleUser, RoleAdmin}
+
+func (r Role) Label() string {
+	switch r {
+	case RoleAdmin:
+		return "管理者"
+	default:
+		return "一般"
+	}
+}
+
+// 空の場合は RoleUser
+func ParseRole(s string) (Role, error) {
+	switch Role(strings.ToLower(strings.TrimSpace(s))) {
+	case "", RoleUser:
+		return RoleUser, nil
+	case RoleAdmin:
+		return RoleAdmin, nil
+	}
+	return "", fmt.Errorf("repo: unknown role %q", s)
+}
+
 type User struct {
 	Id        string
 	Name      string
 	password  string
-	createdAt string
-	updatedAt string
+	Role      Role
+	Active    bool
+	DeletedAt *time.Time // 論理削除された日時。削除されていなければ nil
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// 管理者の操作ができるか。無効化や削除をしたアカウントは管理者でも使えない
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin && u.Active && u.DeletedAt == nil
+}
+
+var ErrWrongPassword = errors.New("repo: current password does not match")
+
+const userColumns = "id, name, role, active, deleted_at, created_at, updated_at"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row scanner) (User, error) {
+	var user User
+	err := row.Scan(&user.Id, &user.Name, &user.Role, &user.Active, &user.DeletedAt, &user.CreatedAt, &user.UpdatedAt)
+	return user, err
 }
 
 type UserRepository struct {
@@ -22,39 +78,29 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
-func (u *UserRepository) Exists(name string) (bool, error) {
-	row := u.db.QueryRow("SELECT id FROM users WHERE name = $1", name)
-	user := &User{}
-	if err := row.Scan(&user.Id); err != nil {
-		if errors.Is(sql.ErrNoRows, err) {
-			return false, nil
-		}
-		return false, err
-	}
-	return user.Id != "", nil
+func (u *UserRepository) Create(name, password string) (*User, error) {
+	return u.CreateWithRole(name, password, RoleUser)
 }
 
-func (u *UserRepository) Create(name, password string) (*User, error) {
+// bcrypt のハッシュ化は CPU を多く使うので、まとめて登録する場合は同時に呼び出す数を制限すること
+func (u *UserRepository) CreateWithRole(name, password string, role Role) (*User, error) {
 	// パスワードはハッシュ化して保存する
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, err
 	}
-	_, err = u.db.Exec("INSERT INTO users (name, password) VALUES ($1, $2)", name, string(hashedPassword))
-	if err != nil {
-		return nil, err
-	}
+	// 同じユーザー名が同時に登録された場合は一意制約違反で ErrDuplicate になる
 	user := &User{}
-	err = u.db.QueryRow("SELECT id,name FROM users WHERE name = $1", name).Scan(&user.Id, &user.Name)
+	err = u.db.QueryRow("INSERT INTO users (name, password, role) VALUES ($1, $2, $3) RETURNING id, name, role", name, string(hashedPassword), role).Scan(&user.Id, &user.Name, &user.Role)
 	if err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
 	return user, nil
 }
 
 func (u *UserRepository) Auth(name, password string) bool {
 	user := &User{}
-	err := u.db.QueryRow("SELECT id, name, password FROM users WHERE name = $1", name).Scan(&user.Id, &user.Name, &user.password)
+	err := u.db.QueryRow("SELECT id, name, password FROM users WHERE name = $1 AND active AND deleted_at IS NULL", name).Scan(&user.Id, &user.Name, &user.password)
 	if err != nil {
 		return false
 	}
@@ -66,17 +112,184 @@ func (u *UserRepository) Auth(name, password string) bool {
 
 func (u *UserRepository) FindByName(name string) *User {
 	user := &User{}
-	err := u.db.QueryRow("SELECT id, name FROM users WHERE name = $1", name).Scan(&user.Id, &user.Name)
+	err := u.db.QueryRow("SELECT id, name FROM users WHERE name = $1 AND deleted_at IS NULL", name).Scan(&user.Id, &user.Name)
 	if err != nil {
 		return nil
 	}
 	return user
 }
 
-func (u *UserRepository) FindAll() (*sql.Rows, error) {
-	rows, err := u.db.Query("SELECT id, name, created_at FROM users")
+// 論理削除されていないユーザーを ID で取得する。見つからない場合は ErrNotFound を返す
+func (u *UserRepository) FindById(id string) (*User, error) {
+	user, err := scanUser(u.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = $1 AND deleted_at IS NULL", id))
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &user, nil
+}
+
+var userSortable = sortable[User]{
+	columns: map[string]sortColumn[User]{
+		"name": {
+			expr:    `name COLLATE "C"`,
+			cast:    "text",
+			key:     func(u User) string { return u.Name },
+			compare: func(u User, v string) int { return strings.Compare(u.Name, v) },
+		},
+		"created_at": {
+			expr: "created_at",
+			cast: "timestamp",
+			key:  func(u User) string { return u.CreatedAt.Format(time.RFC3339Nano) },
+			compare: func(u User, v string) int {
+				t, _ := time.Parse(time.RFC3339Nano, v)
+				return u.CreatedAt.Compare(t)
+			},
+		},
+	},
+	defaultSort: "created_at",
+	idColumn:    "id",
+	id:          func(u User) string { return u.Id },
+	compareId:   func(u User, v string) int { return strings.Compare(u.Id, v) },
+}
+
+// ListOptions の絞り込み条件を WHERE 句にする。論理削除したユーザーは含めない
+func userWhere(opts ListOptions) *where {
+	deleted := "deleted_at IS NULL"
+	if opts.Deleted {
+		deleted = "deleted_at IS NOT NULL"
+	}
+	w := &where{conds: []string{deleted}}
+	if opts.NameContains != "" {
+		w.add("name ILIKE '%%' || $%d || '%%'", escapeLike(opts.NameContains))
+	}
+	if !opts.CreatedFrom.IsZero() {
+		w.add("created_at >= $%d", opts.CreatedFrom)
+	}
+	if !opts.CreatedTo.IsZero() {
+		w.add("created_at < $%d", opts.CreatedTo)
+	}
+	return w
+}
+
+// 条件に一致するユーザーをキーセットページネーションで取得する
+func (u *UserRepository) List(opts ListOptions) (Page[User], error) {
+	w := userWhere(opts)
+	tail, err := userSortable.paginate(w, opts)
+	if err != nil {
+		return Page[User]{}, err
+	}
+
+	rows, err := u.db.Query("SELECT "+userColumns+" FROM users"+w.String()+tail, w.args...)
+	if err != nil {
+		return Page[User]{}, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return Page[User]{}, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return Page[User]{}, err
+	}
+	return userSortable.page(users, opts), nil
+}
+
+// 条件に一致するユーザーを1行ずつ返す。並び順の指定がない場合は登録日時の順
+// 並び替えの列が不正な場合は ErrInvalidSort を返し、クエリは実行しない
+func (u *UserRepository) Export(opts ExportOptions) (iter.Seq2[User, error], error) {
+	if opts.Sort == "" {
+		opts.Sort = userSortable.defaultSort
+	}
+	w := userWhere(opts.ListOptions)
+	tail, err := userSortable.exportTail(opts.ListOptions)
 	if err != nil {
 		return nil, err
 	}
-	return rows, nil
+	return func(yield func(User, error) bool) {
+		rows, err := u.db.Query("SELECT "+userColumns+" FROM users"+w.String()+tail, w.args...)
+		if err != nil {
+			yield(User{}, err)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			user, err := scanUser(rows)
+			if err != nil {
+				yield(User{}, err)
+				return
+			}
+			if !yield(user, nil) {
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			yield(User{}, err)
+		}
+	}, nil
+}
+
+// 更新した行がない場合は ErrNotFound を返す
+func (u *UserRepository) update(query string, args ...any) error {
+	res, err := u.db.Exec(query, args...)
+	if err != nil {
+		return translate(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// 既に使われているユーザー名の場合は ErrDuplicate を返す
+func (u *UserRepository) Rename(id, name string) error {
+	return u.update("UPDATE users SET name = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NULL", id, name)
+}
+
+// 現在のパスワードが一致した場合のみ変更する。一致しない場合は ErrWrongPassword を返す
+func (u *UserRepository) ChangePassword(id, current, password string) error {
+	var hashed string
+	err := u.db.QueryRow("SELECT password FROM users WHERE id = $1 AND deleted_at IS NULL", id).Scan(&hashed)
+	if err != nil {
+		return translate(err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(current)); err != nil {
+		return ErrWrongPassword
+	}
+	newHashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return u.update("UPDATE users SET password = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NULL", id, string(newHashed))
+}
+
+func (u *UserRepository) SetRole(id string, role Role) error {
+	return u.update("UPDATE users SET role = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NULL", id, role)
+}
+
+// 無効化したユーザーはログインできなくなる
+func (u *UserRepository) Deactivate(id string) error {
+	return u.update("UPDATE users SET active = FALSE, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NULL", id)
+}
+
+// 論理削除したユーザーは一覧やログインの対象外になるが、ユーザー名は使われたままになる
+func (u *UserRepository) SoftDelete(id string) error {
+	return u.update("UPDATE users SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NULL", id)
+}
+
+func (u *UserRepository) Restore(id string) error {
+	return u.update("UPDATE users SET deleted_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NOT NULL", id)
+}
+
+func (u *UserRepository) HardDelete(id string) error {
+	return u.update("DELETE FROM users WHERE id = $1", id)
 }
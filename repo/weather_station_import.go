@@ -0,0 +1,317 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+)
+
+// 取り込み時に既存の行とどう突き合わせるか
+type ImportMode string
+
+const (
+	ModeAppend  ImportMode = "append"  // そのまま追加する
+	ModeSkip    ImportMode = "skip"    // 自然キー (city, measured_at) が既にある行は追加しない
+	ModeReplace ImportMode = "replace" // 取り込む都市の既存の行を削除してから追加する
+	ModeUpsert  ImportMode = "upsert"  // 自然キーが既にある行は気温を上書きする
+)
+
+var ImportModes = []ImportMode{ModeAppend, ModeSkip, ModeReplace, ModeUpsert}
+
+// 自然キーで既存の行と突き合わせるモードか。観測日時をアップロード日時で補うと
+// 同じファイルの同じ都市の行が1つにまとまり、取り込み直しても一致しないので、ファイルの観測日時が必要
+func (m ImportMode) Keyed() bool {
+	return m == ModeSkip || m == ModeUpsert
+}
+
+func ParseImportMode(s string) (ImportMode, error) {
+	if s == "" {
+		return ModeAppend, nil
+	}
+	for _, m := range ImportModes {
+		if string(m) == s {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("repo: unknown import mode %q", s)
+}
+
+func (m ImportMode) Label() string {
+	switch m {
+	case ModeSkip:
+		return "重複をスキップ"
+	case ModeReplace:
+		return "都市ごとに置き換え"
+	case ModeUpsert:
+		return "上書き"
+	default:
+		return "追加"
+	}
+}
+
+// 取り込み結果の件数
+type ImportResult struct {
+	Inserted int64
+	Updated  int64
+	Skipped  int64
+	Deleted  int64
+}
+
+func (r *ImportResult) Add(o ImportResult) {
+	r.Inserted += o.Inserted
+	r.Updated += o.Updated
+	r.Skipped += o.Skipped
+	r.Deleted += o.Deleted
+}
+
+// 置き換えモードで同じ取り込みの前のバッチを消さないよう、values は同じ ImportId を持つこと
+func (w *WeatherStationRepository) Import(mode ImportMode, values []WeatherStation) (ImportResult, error) {
+	if mode != ModeReplace {
+		return importValues(w.db, mode, values)
+	}
+	// 削除と自然キーの付け替えと追加を同時に反映する
+	tx, err := w.db.Begin()
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer tx.Rollback()
+	result, err := importValues(tx, mode, values)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ImportResult{}, translate(err)
+	}
+	return result, nil
+}
+
+// 1つのトランザクションで取り込む。Commit するまで他の接続からは見えない
+type WeatherStationTx interface {
+	Import(mode ImportMode, values []WeatherStation) (ImportResult, error)
+	Commit() error
+	Rollback() error
+}
+
+type weatherStationTx struct {
+	tx *sql.Tx
+}
+
+func (w *WeatherStationRepository) Begin() (WeatherStationTx, error) {
+	tx, err := w.db.Begin()
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &weatherStationTx{tx: tx}, nil
+}
+
+func (t *weatherStationTx) Import(mode ImportMode, values []WeatherStation) (ImportResult, error) {
+	return importValues(t.tx, mode, values)
+}
+
+func (t *weatherStationTx) Commit() error {
+	return translate(t.tx.Commit())
+}
+
+func (t *weatherStationTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// 取り込みで追加した行を削除する。上書きモードで更新した既存の行と、置き換えモードで削除した行は元に戻らない
+func (w *WeatherStationRepository) DeleteByImport(importId string) (int64, error) {
+	if !isUuid(importId) {
+		return 0, nil
+	}
+	tx, err := w.db.Begin()
+	if err != nil {
+		return 0, translate(err)
+	}
+	defer tx.Rollback()
+	n, err := deleteRows(tx, "import_id = $1", importId)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, translate(err)
+	}
+	return n, nil
+}
+
+// cond に一致する行を削除して、削除した行の数を返す。トランザクションの中で呼ぶこと
+// keyed の行を削除した自然キーに他の行が残っている場合は、最も古い行を keyed にして突き合わせられるようにしておく
+func deleteRows(q querier, cond string, args ...any) (int64, error) {
+	rows, err := q.Query("WITH deleted AS (DELETE FROM weather_stations WHERE "+cond+" RETURNING city, measured_at, keyed) "+
+		"SELECT city, measured_at::text, keyed FROM deleted", args...)
+	if err != nil {
+		return 0, translate(err)
+	}
+	defer rows.Close()
+
+	var n int64
+	var cities, measuredAts []string
+	for rows.Next() {
+		var city, measuredAt string
+		var keyed bool
+		if err := rows.Scan(&city, &measuredAt, &keyed); err != nil {
+			return 0, err
+		}
+		n++
+		if keyed {
+			cities = append(cities, city)
+			measuredAts = append(measuredAts, measuredAt)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(cities) == 0 {
+		return n, nil
+	}
+
+	_, err = q.Exec(`UPDATE weather_stations SET keyed = TRUE WHERE id IN (
+		SELECT MIN(w.id) FROM weather_stations w
+		JOIN unnest($1::varchar[], $2::timestamp[]) AS d (city, measured_at) ON w.city = d.city AND w.measured_at = d.measured_at
+		GROUP BY w.city, w.measured_at)`, pq.Array(cities), pq.Array(measuredAts))
+	if err != nil {
+		return 0, translate(err)
+	}
+	return n, nil
+}
+
+// 自然キーごとに1行だけ keyed にして追加する。keyed の行が既にある自然キーと、values の中で2回目以降に出てくる行は keyed にしない
+// 追加・置き換えモードで取り込んだ行も、後から重複除外・上書きモードで取り込むときに突き合わせられるようにするため
+func insertRows(q querier, values []WeatherStation) error {
+	placeholders := make([]string, 0, len(values))
+	vals := make([]any, 0, len(values)*6)
+	seen := make(map[naturalKey]bool, len(values))
+	for i, v := range values {
+		n := i * 6
+		placeholders = append(placeholders, fmt.Sprintf("($%d::varchar, $%d::numeric, $%d::timestamp, $%d::uuid, $%d::uuid, $%d::boolean)", n+1, n+2, n+3, n+4, n+5, n+6))
+		vals = append(vals, v.City, v.Temperature, v.MeasuredAt, nullable(v.UploadedBy), nullable(v.ImportId), !seen[keyOf(v)])
+		seen[keyOf(v)] = true
+	}
+
+	// 各自然キーの最初の行を keyed で追加してみて、既に keyed の行があって追加できなかった行は keyed なしで追加する
+	_, err := q.Exec(`WITH v (city, temperature, measured_at, uploaded_by, import_id, is_first) AS (VALUES `+strings.Join(placeholders, ", ")+`),
+	inserted AS (
+		INSERT INTO weather_stations (city, temperature, measured_at, uploaded_by, import_id, keyed)
+		SELECT city, temperature, measured_at, uploaded_by, import_id, TRUE FROM v WHERE is_first
+		ON CONFLICT (city, measured_at) WHERE keyed DO NOTHING
+		RETURNING city, measured_at
+	)
+	INSERT INTO weather_stations (city, temperature, measured_at, uploaded_by, import_id, keyed)
+	SELECT city, temperature, measured_at, uploaded_by, import_id, FALSE FROM v
+	WHERE NOT (is_first AND EXISTS (SELECT 1 FROM inserted i WHERE i.city = v.city AND i.measured_at = v.measured_at))`, vals...)
+	return translate(err)
+}
+
+func importValues(q querier, mode ImportMode, values []WeatherStation) (ImportResult, error) {
+	if len(values) == 0 {
+		return ImportResult{}, nil
+	}
+	switch mode {
+	case ModeAppend:
+		if err := insertRows(q, values); err != nil {
+			return ImportResult{}, err
+		}
+		return ImportResult{Inserted: int64(len(values))}, nil
+	case ModeSkip:
+		return importSkip(q, values)
+	case ModeReplace:
+		return importReplace(q, values)
+	case ModeUpsert:
+		return importUpsert(q, values)
+	}
+	return ImportResult{}, fmt.Errorf("repo: unknown import mode %q", mode)
+}
+
+func importSkip(q querier, values []WeatherStation) (ImportResult, error) {
+	insert, vals := insertQuery(values)
+	res, err := q.Exec(insert+" ON CONFLICT (city, measured_at) WHERE keyed DO NOTHING", vals...)
+	if err != nil {
+		return ImportResult{}, translate(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return ImportResult{}, err
+	}
+	return ImportResult{Inserted: n, Skipped: int64(len(values)) - n}, nil
+}
+
+// トランザクションの中で呼ぶこと
+func importReplace(q querier, values []WeatherStation) (ImportResult, error) {
+	cities := make([]string, 0, len(values))
+	seen := make(map[string]bool)
+	for _, v := range values {
+		if !seen[v.City] {
+			seen[v.City] = true
+			cities = append(cities, v.City)
+		}
+	}
+
+	deleted, err := deleteRows(q, "city = ANY($1) AND import_id IS DISTINCT FROM $2", pq.Array(cities), nullable(values[0].ImportId))
+	if err != nil {
+		return ImportResult{}, err
+	}
+	if err := insertRows(q, values); err != nil {
+		return ImportResult{}, err
+	}
+	return ImportResult{Inserted: int64(len(values)), Deleted: deleted}, nil
+}
+
+func importUpsert(q querier, values []WeatherStation) (ImportResult, error) {
+	// 1つの INSERT で同じ行を2回更新できないので、同じ自然キーはバッチ内で後の行を残す
+	unique, overwritten := dedupeNaturalKey(values)
+
+	// import_id は最初に作った取り込みのまま残し、取り消し時に他の取り込みの行を消さないようにする
+	insert, vals := insertQuery(unique)
+	rows, err := q.Query(insert+` ON CONFLICT (city, measured_at) WHERE keyed
+		DO UPDATE SET temperature = EXCLUDED.temperature, uploaded_by = EXCLUDED.uploaded_by
+		RETURNING xmax = 0`, vals...)
+	if err != nil {
+		return ImportResult{}, translate(err)
+	}
+	defer rows.Close()
+
+	result := ImportResult{Updated: overwritten}
+	for rows.Next() {
+		var inserted bool
+		if err := rows.Scan(&inserted); err != nil {
+			return ImportResult{}, err
+		}
+		if inserted {
+			result.Inserted++
+		} else {
+			result.Updated++
+		}
+	}
+	return result, rows.Err()
+}
+
+type naturalKey struct {
+	city       string
+	measuredAt int64
+}
+
+// measured_at は TIMESTAMP（タイムゾーンなし）なので、PostgreSQL と同じく時差を除いた日時をマイクロ秒に丸めて比べる
+func keyOf(v WeatherStation) naturalKey {
+	t := v.MeasuredAt
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	return naturalKey{city: v.City, measuredAt: wall.Round(time.Microsecond).UnixMicro()}
+}
+
+// 自然キーが重複する行は後の行を残す。上書きされた行の数も返す
+func dedupeNaturalKey(values []WeatherStation) ([]WeatherStation, int64) {
+	index := make(map[naturalKey]int, len(values))
+	unique := make([]WeatherStation, 0, len(values))
+	for _, v := range values {
+		if i, ok := index[keyOf(v)]; ok {
+			unique[i] = v
+			continue
+		}
+		index[keyOf(v)] = len(unique)
+		unique = append(unique, v)
+	}
+	return unique, int64(len(values) - len(unique))
+}
@@ -0,0 +1,327 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// WeatherStationStore のメモリ上の実装
+type MemoryWeatherStationStore struct {
+	mu       sync.RWMutex
+	stations []WeatherStation
+	keys     map[naturalKey]int // 自然キーごとに1行だけある keyed の行の stations 内の位置
+	nextId   int64
+}
+
+func NewMemoryWeatherStationStore() *MemoryWeatherStationStore {
+	return &MemoryWeatherStationStore{keys: make(map[naturalKey]int), nextId: 1}
+}
+
+func (m *MemoryWeatherStationStore) BulkInsert(values []WeatherStation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, v := range values {
+		m.insert(v)
+	}
+	return nil
+}
+
+// 追加した行の ID を返す。自然キーに keyed の行がまだなければ keyed にする（insertRows と同じ）
+func (m *MemoryWeatherStationStore) insert(v WeatherStation) int64 {
+	v.Id = m.nextId
+	m.nextId++
+	if _, ok := m.keys[keyOf(v)]; !ok {
+		v.keyed = true
+		m.keys[keyOf(v)] = len(m.stations)
+	}
+	m.stations = append(m.stations, v)
+	return v.Id
+}
+
+func (m *MemoryWeatherStationStore) Import(mode ImportMode, values []WeatherStation) (ImportResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.apply(mode, values, nil)
+}
+
+// 取り消すための記録。トランザクションの中で変更した内容
+type undoLog struct {
+	inserted map[int64]bool
+	updated  []WeatherStation // 更新前の行
+	deleted  []WeatherStation
+}
+
+// ロックを取った状態で呼ぶこと。undo が nil でない場合は変更を記録する
+func (m *MemoryWeatherStationStore) apply(mode ImportMode, values []WeatherStation, undo *undoLog) (ImportResult, error) {
+	var result ImportResult
+	insert := func(v WeatherStation) {
+		id := m.insert(v)
+		if undo != nil {
+			undo.inserted[id] = true
+		}
+		result.Inserted++
+	}
+	switch mode {
+	case ModeAppend:
+		for _, v := range values {
+			insert(v)
+		}
+	case ModeSkip:
+		for _, v := range values {
+			if _, ok := m.keys[keyOf(v)]; ok {
+				result.Skipped++
+				continue
+			}
+			insert(v)
+		}
+	case ModeReplace:
+		if len(values) == 0 {
+			break
+		}
+		cities := make(map[string]bool)
+		for _, v := range values {
+			cities[v.City] = true
+		}
+		kept := m.stations[:0]
+		for _, s := range m.stations {
+			if cities[s.City] && s.ImportId != values[0].ImportId {
+				if undo != nil {
+					undo.deleted = append(undo.deleted, s)
+				}
+				result.Deleted++
+				continue
+			}
+			kept = append(kept, s)
+		}
+		m.stations = kept
+		promoted := m.reindex()
+		if undo != nil {
+			undo.updated = append(undo.updated, promoted...)
+		}
+		for _, v := range values {
+			insert(v)
+		}
+	case ModeUpsert:
+		unique, overwritten := dedupeNaturalKey(values)
+		result.Updated = overwritten
+		for _, v := range unique {
+			if i, ok := m.keys[keyOf(v)]; ok {
+				if undo != nil {
+					undo.updated = append(undo.updated, m.stations[i])
+				}
+				m.stations[i].Temperature = v.Temperature
+				m.stations[i].UploadedBy = v.UploadedBy
+				result.Updated++
+				continue
+			}
+			insert(v)
+		}
+	default:
+		return ImportResult{}, fmt.Errorf("repo: unknown import mode %q", mode)
+	}
+	return result, nil
+}
+
+// メモリ上の実装では変更はすぐに他から見え、Rollback で記録を元に戻す
+type memoryWeatherStationTx struct {
+	m    *MemoryWeatherStationStore
+	undo *undoLog
+	done bool
+}
+
+func (m *MemoryWeatherStationStore) Begin() (WeatherStationTx, error) {
+	return &memoryWeatherStationTx{m: m, undo: &undoLog{inserted: make(map[int64]bool)}}, nil
+}
+
+func (t *memoryWeatherStationTx) Import(mode ImportMode, values []WeatherStation) (ImportResult, error) {
+	if t.done {
+		return ImportResult{}, sql.ErrTxDone
+	}
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+	return t.m.apply(mode, values, t.undo)
+}
+
+func (t *memoryWeatherStationTx) Commit() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	return nil
+}
+
+func (t *memoryWeatherStationTx) Rollback() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+
+	// 削除した行を戻してから更新前の値に戻し、追加した行を除く
+	// 更新した後に削除した行もあるので、削除した行を先に戻しておく
+	t.m.stations = append(t.m.stations, t.undo.deleted...)
+	index := make(map[int64]int, len(t.m.stations))
+	for i, s := range t.m.stations {
+		index[s.Id] = i
+	}
+	for i := len(t.undo.updated) - 1; i >= 0; i-- {
+		prev := t.undo.updated[i]
+		if j, ok := index[prev.Id]; ok {
+			t.m.stations[j] = prev
+		}
+	}
+	kept := t.m.stations[:0]
+	for _, s := range t.m.stations {
+		if !t.undo.inserted[s.Id] {
+			kept = append(kept, s)
+		}
+	}
+	t.m.stations = kept
+	t.m.reindex()
+	return nil
+}
+
+func (m *MemoryWeatherStationStore) DeleteByImport(importId string) (int64, error) {
+	if importId == "" {
+		return 0, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	kept := m.stations[:0]
+	for _, s := range m.stations {
+		if s.ImportId == importId {
+			n++
+			continue
+		}
+		kept = append(kept, s)
+	}
+	m.stations = kept
+	m.reindex()
+	return n, nil
+}
+
+// 行を削除した後に自然キーの位置を作り直す。keyed の行がなくなった自然キーは ID が最も小さい行を keyed にする（deleteRows と同じ）
+// keyed にした行の変更前の値を返す
+func (m *MemoryWeatherStationStore) reindex() []WeatherStation {
+	clear(m.keys)
+	for i, s := range m.stations {
+		if s.keyed {
+			m.keys[keyOf(s)] = i
+		}
+	}
+	oldest := make(map[naturalKey]int)
+	for i, s := range m.stations {
+		if _, ok := m.keys[keyOf(s)]; ok {
+			continue
+		}
+		if j, ok := oldest[keyOf(s)]; !ok || s.Id < m.stations[j].Id {
+			oldest[keyOf(s)] = i
+		}
+	}
+	promoted := make([]WeatherStation, 0, len(oldest))
+	for key, i := range oldest {
+		promoted = append(promoted, m.stations[i])
+		m.stations[i].keyed = true
+		m.keys[key] = i
+	}
+	return promoted
+}
+
+func (m *MemoryWeatherStationStore) Stats(filter StatsFilter) ([]CityStat, error) {
+	if err := checkImportId(filter.ImportId); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sums := make(map[string]float64)
+	byCity := make(map[string]*CityStat)
+	for _, s := range m.stations {
+		if !strings.HasPrefix(s.City, filter.CityPrefix) {
+			continue
+		}
+		if filter.ImportId != "" && s.ImportId != filter.ImportId {
+			continue
+		}
+		t := float64(s.Temperature)
+		stat, ok := byCity[s.City]
+		if !ok {
+			stat = &CityStat{City: s.City, Min: t, Max: t}
+			byCity[s.City] = stat
+		}
+		stat.Min = min(stat.Min, t)
+		stat.Max = max(stat.Max, t)
+		stat.Count++
+		sums[s.City] += t
+	}
+
+	stats := make([]CityStat, 0, len(byCity))
+	for city, stat := range byCity {
+		stat.Mean = sums[city] / float64(stat.Count)
+		stats = append(stats, *stat)
+	}
+	// COLLATE "C" と同じくバイト順で並べる
+	slices.SortFunc(stats, func(a, b CityStat) int {
+		return strings.Compare(a.City, b.City)
+	})
+	return stats, nil
+}
+
+func (m *MemoryWeatherStationStore) List(opts ListOptions) (Page[WeatherStation], error) {
+	if err := checkImportId(opts.ImportId); err != nil {
+		return Page[WeatherStation]{}, err
+	}
+	return weatherStationSortable.paginateSlice(m.filter(opts), opts)
+}
+
+func (m *MemoryWeatherStationStore) Export(opts ExportOptions) (iter.Seq2[WeatherStation, error], error) {
+	if err := weatherStationSortable.checkSort(opts.ListOptions); err != nil {
+		return nil, err
+	}
+	if err := checkImportId(opts.ImportId); err != nil {
+		return nil, err
+	}
+	return func(yield func(WeatherStation, error) bool) {
+		stations := weatherStationSortable.exportSlice(m.filter(opts.ListOptions), opts.ListOptions)
+		for _, s := range stations {
+			if !yield(s, nil) {
+				return
+			}
+		}
+	}, nil
+}
+
+// stationWhere と同じ条件に一致する行の複製
+func (m *MemoryWeatherStationStore) filter(opts ListOptions) []WeatherStation {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var stations []WeatherStation
+	for _, s := range m.stations {
+		if opts.City != "" && s.City != opts.City {
+			continue
+		}
+		if opts.MinTemperature != nil && float64(s.Temperature) < *opts.MinTemperature {
+			continue
+		}
+		if opts.MaxTemperature != nil && float64(s.Temperature) > *opts.MaxTemperature {
+			continue
+		}
+		if opts.ImportId != "" && s.ImportId != opts.ImportId {
+			continue
+		}
+		if !opts.MeasuredFrom.IsZero() && s.MeasuredAt.Before(opts.MeasuredFrom) {
+			continue
+		}
+		if !opts.MeasuredTo.IsZero() && !s.MeasuredAt.Before(opts.MeasuredTo) {
+			continue
+		}
+		stations = append(stations, s)
+	}
+	return stations
+}
@@ -0,0 +1,327 @@
+package repo
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+var (
+	hour1 = time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+	hour2 = hour1.Add(time.Hour)
+)
+
+func station(city string, temperature float32, measuredAt time.Time, importId string) WeatherStation {
+	return WeatherStation{City: city, Temperature: temperature, MeasuredAt: measuredAt, ImportId: importId}
+}
+
+// 比較用に ID 順に並べた行
+func rows(m *MemoryWeatherStationStore) []WeatherStation {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stations := slices.Clone(m.stations)
+	slices.SortFunc(stations, func(a, b WeatherStation) int { return cmp.Compare(a.Id, b.Id) })
+	return stations
+}
+
+// 都市=気温 の形式。keyed の行には * を付ける
+func describe(stations []WeatherStation) []string {
+	got := make([]string, 0, len(stations))
+	for _, s := range stations {
+		mark := ""
+		if s.keyed {
+			mark = "*"
+		}
+		got = append(got, fmt.Sprintf("%s@%d=%g%s", s.City, s.MeasuredAt.Hour(), s.Temperature, mark))
+	}
+	return got
+}
+
+// 自然キーの位置が keyed の行を指しているか
+func checkKeys(t *testing.T, m *MemoryWeatherStationStore) {
+	t.Helper()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keyed := 0
+	for _, s := range m.stations {
+		if s.keyed {
+			keyed++
+		}
+	}
+	if keyed != len(m.keys) {
+		t.Errorf("%d keyed rows, %d keys", keyed, len(m.keys))
+	}
+	for key, i := range m.keys {
+		if i >= len(m.stations) || !m.stations[i].keyed || keyOf(m.stations[i]) != key {
+			t.Errorf("key %v points to row %d", key, i)
+		}
+	}
+}
+
+// 東京と大阪の行が1つずつある保存先
+func existingStations(t *testing.T) (*MemoryWeatherStationStore, string) {
+	t.Helper()
+	m := NewMemoryWeatherStationStore()
+	importId := NewImportId()
+	if _, err := m.Import(ModeAppend, []WeatherStation{
+		station("Tokyo", 10, hour1, importId),
+		station("Osaka", 20, hour1, importId),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return m, importId
+}
+
+func TestMemoryWeatherStationStoreImport(t *testing.T) {
+	importId := NewImportId()
+	batch := []WeatherStation{
+		station("Tokyo", 11, hour1, importId),
+		station("Tokyo", 12, hour2, importId),
+		station("Nagoya", 30, hour1, importId),
+	}
+
+	tests := []struct {
+		name   string
+		mode   ImportMode
+		values []WeatherStation
+		want   ImportResult
+		rows   []string
+	}{
+		{"append", ModeAppend, batch, ImportResult{Inserted: 3},
+			[]string{"Tokyo@9=10*", "Osaka@9=20*", "Tokyo@9=11", "Tokyo@10=12*", "Nagoya@9=30*"}},
+		{"skip", ModeSkip, batch, ImportResult{Inserted: 2, Skipped: 1},
+			[]string{"Tokyo@9=10*", "Osaka@9=20*", "Tokyo@10=12*", "Nagoya@9=30*"}},
+		{"replace", ModeReplace, batch, ImportResult{Inserted: 3, Deleted: 1},
+			[]string{"Osaka@9=20*", "Tokyo@9=11*", "Tokyo@10=12*", "Nagoya@9=30*"}},
+		{"upsert", ModeUpsert, batch, ImportResult{Inserted: 2, Updated: 1},
+			[]string{"Tokyo@9=11*", "Osaka@9=20*", "Tokyo@10=12*", "Nagoya@9=30*"}},
+		{"skip duplicates in the batch", ModeSkip, []WeatherStation{
+			station("Nagoya", 30, hour1, importId),
+			station("Nagoya", 31, hour1, importId),
+		}, ImportResult{Inserted: 1, Skipped: 1},
+			[]string{"Tokyo@9=10*", "Osaka@9=20*", "Nagoya@9=30*"}},
+		{"upsert keeps the last duplicate in the batch", ModeUpsert, []WeatherStation{
+			station("Tokyo", 11, hour1, importId),
+			station("Tokyo", 13, hour1, importId),
+		}, ImportResult{Updated: 2},
+			[]string{"Tokyo@9=13*", "Osaka@9=20*"}},
+		{"same wall time in another zone", ModeSkip, []WeatherStation{
+			station("Tokyo", 11, time.Date(2024, 7, 1, 9, 0, 0, 0, time.FixedZone("JST", 9*60*60)), importId),
+		}, ImportResult{Skipped: 1},
+			[]string{"Tokyo@9=10*", "Osaka@9=20*"}},
+		{"replace keeps rows of the same import", ModeReplace, []WeatherStation{
+			station("Tokyo", 11, hour1, importId),
+		}, ImportResult{Inserted: 1, Deleted: 1},
+			[]string{"Osaka@9=20*", "Tokyo@9=11*"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := existingStations(t)
+			got, err := m.Import(tt.mode, tt.values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("result = %+v, want %+v", got, tt.want)
+			}
+			if got := describe(rows(m)); !slices.Equal(got, tt.rows) {
+				t.Errorf("rows = %q, want %q", got, tt.rows)
+			}
+			checkKeys(t, m)
+		})
+	}
+
+	m, _ := existingStations(t)
+	if _, err := m.Import("merge", batch); err == nil {
+		t.Error("unknown mode: err = nil")
+	}
+}
+
+func TestMemoryWeatherStationStoreDeleteByImport(t *testing.T) {
+	m, first := existingStations(t)
+	second := NewImportId()
+	if _, err := m.Import(ModeAppend, []WeatherStation{
+		station("Tokyo", 11, hour1, second),
+		station("Tokyo", 12, hour1, second),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := m.DeleteByImport(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("deleted %d rows, want 2", n)
+	}
+	// 削除した keyed の行の代わりに、残った行のうち最も古い行が keyed になる
+	if got, want := describe(rows(m)), []string{"Tokyo@9=11*", "Tokyo@9=12"}; !slices.Equal(got, want) {
+		t.Errorf("rows = %q, want %q", got, want)
+	}
+	checkKeys(t, m)
+
+	// 付け替えた自然キーで突き合わせる
+	result, err := m.Import(ModeUpsert, []WeatherStation{station("Tokyo", 15, hour1, NewImportId())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (ImportResult{Updated: 1}) {
+		t.Errorf("upsert result = %+v", result)
+	}
+	if got, want := describe(rows(m)), []string{"Tokyo@9=15*", "Tokyo@9=12"}; !slices.Equal(got, want) {
+		t.Errorf("rows = %q, want %q", got, want)
+	}
+
+	for _, id := range []string{"", first} {
+		if n, err := m.DeleteByImport(id); err != nil || n != 0 {
+			t.Errorf("DeleteByImport(%q) = %d, %v, want 0", id, n, err)
+		}
+	}
+}
+
+func TestMemoryWeatherStationTxRollback(t *testing.T) {
+	importId := NewImportId()
+	tests := []struct {
+		name    string
+		imports func(tx WeatherStationTx) error
+	}{
+		{"append", func(tx WeatherStationTx) error {
+			_, err := tx.Import(ModeAppend, []WeatherStation{station("Tokyo", 11, hour1, importId), station("Kyoto", 25, hour1, importId)})
+			return err
+		}},
+		{"skip", func(tx WeatherStationTx) error {
+			_, err := tx.Import(ModeSkip, []WeatherStation{station("Tokyo", 11, hour1, importId), station("Kyoto", 25, hour1, importId)})
+			return err
+		}},
+		{"replace", func(tx WeatherStationTx) error {
+			_, err := tx.Import(ModeReplace, []WeatherStation{station("Tokyo", 11, hour2, importId)})
+			return err
+		}},
+		{"upsert", func(tx WeatherStationTx) error {
+			_, err := tx.Import(ModeUpsert, []WeatherStation{station("Tokyo", 11, hour1, importId), station("Osaka", 21, hour1, importId)})
+			return err
+		}},
+		{"several batches", func(tx WeatherStationTx) error {
+			// 前のバッチで追加した行を後のバッチで更新し、上書きした既存の行を後のバッチで削除する
+			if _, err := tx.Import(ModeReplace, []WeatherStation{station("Tokyo", 11, hour1, importId)}); err != nil {
+				return err
+			}
+			if _, err := tx.Import(ModeUpsert, []WeatherStation{station("Tokyo", 12, hour1, importId), station("Osaka", 22, hour1, importId)}); err != nil {
+				return err
+			}
+			_, err := tx.Import(ModeReplace, []WeatherStation{station("Osaka", 23, hour2, importId)})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := existingStations(t)
+			before := rows(m)
+
+			tx, err := m.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.imports(tx); err != nil {
+				t.Fatal(err)
+			}
+			if slices.Equal(describe(rows(m)), describe(before)) {
+				t.Fatal("import changed nothing")
+			}
+			if err := tx.Rollback(); err != nil {
+				t.Fatal(err)
+			}
+			if got := rows(m); !slices.Equal(got, before) {
+				t.Errorf("rows after rollback = %q, want %q", describe(got), describe(before))
+			}
+			checkKeys(t, m)
+
+			if _, err := tx.Import(ModeAppend, []WeatherStation{station("Kyoto", 25, hour1, importId)}); !errors.Is(err, sql.ErrTxDone) {
+				t.Errorf("Import after rollback: err = %v, want ErrTxDone", err)
+			}
+			if err := tx.Rollback(); !errors.Is(err, sql.ErrTxDone) {
+				t.Errorf("second Rollback: err = %v, want ErrTxDone", err)
+			}
+		})
+	}
+}
+
+func TestMemoryWeatherStationTxCommit(t *testing.T) {
+	m, _ := existingStations(t)
+	tx, err := m.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Import(ModeUpsert, []WeatherStation{station("Tokyo", 11, hour1, NewImportId())}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); !errors.Is(err, sql.ErrTxDone) {
+		t.Errorf("Rollback after commit: err = %v, want ErrTxDone", err)
+	}
+	if got, want := describe(rows(m)), []string{"Tokyo@9=11*", "Osaka@9=20*"}; !slices.Equal(got, want) {
+		t.Errorf("rows = %q, want %q", got, want)
+	}
+}
+
+func TestMemoryWeatherStationStoreStats(t *testing.T) {
+	m, first := existingStations(t)
+	second := NewImportId()
+	if _, err := m.Import(ModeAppend, []WeatherStation{
+		station("Tokyo", 14, hour2, second),
+		station("Toyama", 5, hour1, second),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter StatsFilter
+		want   []string
+	}{
+		{"all", StatsFilter{}, []string{"Osaka=20.0/20.0/20.0", "Tokyo=10.0/12.0/14.0", "Toyama=5.0/5.0/5.0"}},
+		{"city prefix", StatsFilter{CityPrefix: "To"}, []string{"Tokyo=10.0/12.0/14.0", "Toyama=5.0/5.0/5.0"}},
+		{"one import", StatsFilter{ImportId: first}, []string{"Osaka=20.0/20.0/20.0", "Tokyo=10.0/10.0/10.0"}},
+		{"unknown import", StatsFilter{ImportId: NewImportId()}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, err := m.Stats(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(stats))
+			for _, s := range stats {
+				got = append(got, s.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryWeatherStationStoreInvalidImport(t *testing.T) {
+	m, _ := existingStations(t)
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"stats", func() error { _, err := m.Stats(StatsFilter{ImportId: "abc"}); return err }},
+		{"list", func() error { _, err := m.List(ListOptions{ImportId: "abc"}); return err }},
+		{"export", func() error { _, err := m.Export(ExportOptions{ListOptions{ImportId: "abc"}}); return err }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, ErrInvalidImport) {
+				t.Errorf("err = %v, want ErrInvalidImport", err)
+			}
+		})
+	}
+}
@@ -1,15 +1,62 @@
 package repo
 
 import (
+	"cmp"
 	"database/sql"
 	"fmt"
-	"log"
+	"iter"
+	"math"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type WeatherStation struct {
+	Id          int64
 	City        string
 	Temperature float32
+	MeasuredAt  time.Time
+	UploadedBy  string // アップロードしたユーザーのID。ユーザーが削除された場合は空
+	ImportId    string // 同じファイルから取り込んだ行に共通のID
+	keyed       bool   // 自然キー (city, measured_at) で一意にする行か
+}
+
+const weatherStationColumns = "id, city, temperature, measured_at, COALESCE(uploaded_by::text, ''), COALESCE(import_id::text, '')"
+
+func scanWeatherStation(row scanner) (WeatherStation, error) {
+	var s WeatherStation
+	err := row.Scan(&s.Id, &s.City, &s.Temperature, &s.MeasuredAt, &s.UploadedBy, &s.ImportId)
+	return s, err
+}
+
+// 複数行の INSERT 文とその引数を組み立てる。行は keyed で、自然キー (city, measured_at) で一意になる
+// ON CONFLICT を付けて重複除外・上書きモードで使う
+func insertQuery(values []WeatherStation) (string, []any) {
+	insert := "INSERT INTO weather_stations(city, temperature, measured_at, uploaded_by, import_id, keyed) VALUES "
+
+	placeholders := make([]string, 0, len(values))
+	vals := make([]any, 0, len(values)*6)
+
+	for i, k := range values {
+		n := i * 6
+		placeholders = append(placeholders, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6))
+		vals = append(vals, k.City, k.Temperature, k.MeasuredAt, nullable(k.UploadedBy), nullable(k.ImportId), true)
+	}
+
+	// Join placeholders and finalize the query
+	return insert + strings.Join(placeholders, ", "), vals
+}
+
+// 取り込み単位を識別する ID を生成する
+func NewImportId() string {
+	return newId()
+}
+
+func nullable(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
 }
 
 type WeatherStationRepository struct {
@@ -20,34 +67,217 @@ func NewWeatherStationRepository(db *sql.DB) *WeatherStationRepository {
 	return &WeatherStationRepository{db: db}
 }
 
+// 追加モードと同じく、各自然キーの最初の行は重複除外・上書きモードで突き合わせられる
 func (w *WeatherStationRepository) BulkInsert(values []WeatherStation) error {
-	insert := "INSERT INTO weather_stations(city, temperature) VALUES "
+	if len(values) == 0 {
+		return nil
+	}
+	return insertRows(w.db, values)
+}
 
-	placeholders := make([]string, 0, len(values))
-	vals := make([]any, 0, len(values)*2)
+// 都市ごとの集計結果
+type CityStat struct {
+	City  string
+	Min   float64
+	Mean  float64
+	Max   float64
+	Count int64
+}
 
-	for i, k := range values {
-		placeholders = append(placeholders, fmt.Sprintf("($%d, $%d)", i*2+1, i*2+2))
-		vals = append(vals, k.City, k.Temperature)
+// 集計条件
+type StatsFilter struct {
+	CityPrefix string
+	ImportId   string
+}
+
+// One Billion Row Challenge と同じく小数点以下1桁に丸めて City=min/mean/max 形式で返す
+func (s CityStat) String() string {
+	r := s.Rounded()
+	return fmt.Sprintf("%s=%.1f/%.1f/%.1f", r.City, r.Min, r.Mean, r.Max)
+}
+
+// 最小・平均・最大を小数点以下1桁に丸めた値を返す
+func (s CityStat) Rounded() CityStat {
+	s.Min, s.Mean, s.Max = round(s.Min), round(s.Mean), round(s.Max)
+	return s
+}
+
+// 1BRC の仕様に合わせて正の無限大方向に丸める
+func round(v float64) float64 {
+	return math.Floor(v*10+0.5) / 10
+}
+
+// 取り込みIDの絞り込みは uuid の形式でなければ ErrInvalidImport。空の場合は絞り込まない
+func checkImportId(importId string) error {
+	if importId != "" && !isUuid(importId) {
+		return fmt.Errorf("%w %q", ErrInvalidImport, importId)
 	}
+	return nil
+}
 
-	// Join placeholders and finalize the query
-	insert += strings.Join(placeholders, ", ")
+// 都市ごとの最小・平均・最大・件数を都市名のコードポイント順で返す
+// 取り込みIDが uuid の形式でない場合は ErrInvalidImport を返し、クエリは実行しない
+func (w *WeatherStationRepository) Stats(filter StatsFilter) ([]CityStat, error) {
+	if err := checkImportId(filter.ImportId); err != nil {
+		return nil, err
+	}
+	cond := &where{}
+	if filter.CityPrefix != "" {
+		cond.add("city LIKE $%d", escapeLike(filter.CityPrefix)+"%")
+	}
+	if filter.ImportId != "" {
+		cond.add("import_id = $%d", filter.ImportId)
+	}
+	query := "SELECT city, MIN(temperature), AVG(temperature), MAX(temperature), COUNT(*) FROM weather_stations" +
+		cond.String() + ` GROUP BY city ORDER BY city COLLATE "C"`
+
+	rows, err := w.db.Query(query, cond.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []CityStat
+	for rows.Next() {
+		var s CityStat
+		if err := rows.Scan(&s.City, &s.Min, &s.Mean, &s.Max, &s.Count); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// 条件に一致する観測値を1行ずつ返す。並び順の指定がない場合は取り込んだ順
+// 並び替えの列が不正な場合は ErrInvalidSort、取り込みIDが不正な場合は ErrInvalidImport を返し、クエリは実行しない
+func (w *WeatherStationRepository) Export(opts ExportOptions) (iter.Seq2[WeatherStation, error], error) {
+	if err := checkImportId(opts.ImportId); err != nil {
+		return nil, err
+	}
+	cond := stationWhere(opts.ListOptions)
+	tail, err := weatherStationSortable.exportTail(opts.ListOptions)
+	if err != nil {
+		return nil, err
+	}
+	return func(yield func(WeatherStation, error) bool) {
+		rows, err := w.db.Query("SELECT "+weatherStationColumns+" FROM weather_stations"+cond.String()+tail, cond.args...)
+		if err != nil {
+			yield(WeatherStation{}, err)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			s, err := scanWeatherStation(rows)
+			if err != nil {
+				yield(WeatherStation{}, err)
+				return
+			}
+			if !yield(s, nil) {
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			yield(WeatherStation{}, err)
+		}
+	}, nil
+}
 
-	fmt.Println("Final Query: ", insert)
+// LIKE のワイルドカードをエスケープする
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+var weatherStationSortable = sortable[WeatherStation]{
+	columns: map[string]sortColumn[WeatherStation]{
+		"city": {
+			expr:    `city COLLATE "C"`,
+			cast:    "text",
+			key:     func(s WeatherStation) string { return s.City },
+			compare: func(s WeatherStation, v string) int { return strings.Compare(s.City, v) },
+		},
+		"measured_at": {
+			expr: "measured_at",
+			cast: "timestamp",
+			key:  func(s WeatherStation) string { return s.MeasuredAt.Format(time.RFC3339Nano) },
+			compare: func(s WeatherStation, v string) int {
+				t, _ := time.Parse(time.RFC3339Nano, v)
+				return s.MeasuredAt.Compare(t)
+			},
+		},
+		"temperature": {
+			expr: "temperature",
+			cast: "numeric",
+			key:  func(s WeatherStation) string { return strconv.FormatFloat(float64(s.Temperature), 'f', -1, 32) },
+			compare: func(s WeatherStation, v string) int {
+				t, _ := strconv.ParseFloat(v, 32)
+				return cmp.Compare(s.Temperature, float32(t))
+			},
+		},
+	},
+	defaultSort: "city",
+	idColumn:    "id",
+	id:          func(s WeatherStation) string { return strconv.FormatInt(s.Id, 10) },
+	compareId: func(s WeatherStation, v string) int {
+		id, _ := strconv.ParseInt(v, 10, 64)
+		return cmp.Compare(s.Id, id)
+	},
+}
+
+// ListOptions の絞り込み条件を WHERE 句にする
+func stationWhere(opts ListOptions) *where {
+	cond := &where{}
+	if opts.City != "" {
+		cond.add("city = $%d", opts.City)
+	}
+	if opts.MinTemperature != nil {
+		cond.add("temperature >= $%d", *opts.MinTemperature)
+	}
+	if opts.MaxTemperature != nil {
+		cond.add("temperature <= $%d", *opts.MaxTemperature)
+	}
+	if opts.ImportId != "" {
+		cond.add("import_id = $%d", opts.ImportId)
+	}
+	if !opts.MeasuredFrom.IsZero() {
+		cond.add("measured_at >= $%d", opts.MeasuredFrom)
+	}
+	if !opts.MeasuredTo.IsZero() {
+		cond.add("measured_at < $%d", opts.MeasuredTo)
+	}
+	return cond
+}
 
-	// Prepare and execute
-	stmt, err := w.db.Prepare(insert)
+// 条件に一致する観測値をキーセットページネーションで取得する
+func (w *WeatherStationRepository) List(opts ListOptions) (Page[WeatherStation], error) {
+	if err := checkImportId(opts.ImportId); err != nil {
+		return Page[WeatherStation]{}, err
+	}
+	cond := stationWhere(opts)
+	tail, err := weatherStationSortable.paginate(cond, opts)
 	if err != nil {
-		log.Fatal("Prepare error: ", err)
-		return err
+		return Page[WeatherStation]{}, err
 	}
-	defer stmt.Close()
 
-	if _, err := stmt.Exec(vals...); err != nil {
-		log.Fatal("Exec error: ", err)
-		return err
+	rows, err := w.db.Query("SELECT "+weatherStationColumns+" FROM weather_stations"+cond.String()+tail, cond.args...)
+	if err != nil {
+		return Page[WeatherStation]{}, err
 	}
+	defer rows.Close()
 
-	return nil
+	var stations []WeatherStation
+	for rows.Next() {
+		s, err := scanWeatherStation(rows)
+		if err != nil {
+			return Page[WeatherStation]{}, err
+		}
+		stations = append(stations, s)
+	}
+	if err := rows.Err(); err != nil {
+		return Page[WeatherStation]{}, err
+	}
+	return weatherStationSortable.page(stations, opts), nil
 }
@@ -0,0 +1,8 @@
+package template
+
+import "embed"
+
+// 画面のテンプレート。起動したディレクトリに関係なく読めるようにバイナリに埋め込む
+//
+//go:embed *.html
+var Files embed.FS